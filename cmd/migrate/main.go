@@ -0,0 +1,94 @@
+// Команда migrate - просмотр и применение миграций схемы БД:
+//
+//	migrate -d <postgres url> status    список миграций и отметка о применении
+//	migrate -d <postgres url> up [n]    применение n (по умолчанию всех) неприменённых миграций
+//	migrate -d <postgres url> down [n]  откат n (по умолчанию одной) последних миграций
+//	migrate -d <postgres url> down all  откат всех миграций, включая удаление таблицы urls
+//
+// Если флаг -d не задан, используется переменная окружения DATABASE_DSN
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+	dsn := flag.String("d", os.Getenv("DATABASE_DSN"), "postgres url")
+	flag.Parse()
+	if *dsn == "" {
+		log.Fatal("postgres url is not set: use -d or DATABASE_DSN")
+	}
+
+	command, n := "status", 0
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+	}
+	if command == "down" {
+		n = 1
+	}
+	if flag.NArg() > 1 {
+		n = parseCount(command, flag.Arg(1))
+	}
+
+	ctx := context.Background()
+	dbPool, err := db.Connect(ctx, *dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	switch command {
+	case "status":
+		err = printStatus(ctx, &dbPool)
+	case "up":
+		_, err = dbPool.MigrateUp(ctx, n)
+	case "down":
+		_, err = dbPool.MigrateDown(ctx, n)
+	default:
+		err = fmt.Errorf("unknown command %q: use status, up or down", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseCount количество миграций команды. Откат всех миграций удаляет данные,
+// поэтому для down он задается только явно словом all, а не числом 0
+func parseCount(command string, arg string) int {
+	if command == "down" && arg == "all" {
+		return 0
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 {
+		log.Fatalf("bad number of migrations %q", arg)
+	}
+	if command == "down" && n == 0 {
+		log.Fatal(`bad number of migrations 0: use "down all" to revert every migration`)
+	}
+	return n
+}
+
+func printStatus(ctx context.Context, dbPool *db.T) error {
+	status, err := dbPool.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, s := range status {
+		applied := "pending"
+		if s.Applied {
+			applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		} else {
+			pending++
+		}
+		fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+	}
+	fmt.Printf("%d pending migrations\n", pending)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"log"
+	"os"
+)
+
+// runExport выгрузка записей БД в новый файл хранилища. Существующий файл дополняется
+// только при продолжении прерванной выгрузки
+func runExport(ctx context.Context, dbPool *db.T, opts options) error {
+	total, err := dbPool.Count(ctx)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		fmt.Printf("dry run: %d entities would be exported to %s\n", total, opts.fileName)
+		return nil
+	}
+
+	cp, err := loadCheckpoint(opts.checkpointName, "export")
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		if info, err := os.Stat(opts.fileName); err == nil && info.Size() > 0 {
+			return fmt.Errorf("file %s already exists: export writes a new file", opts.fileName)
+		}
+		cp = &checkpointT{Command: "export"}
+	} else {
+		log.Printf("resuming export after row %d\n", cp.LastID)
+	}
+
+	file, err := os.OpenFile(opts.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	exported := 0
+	for {
+		entities, lastID, err := dbPool.SelectPage(ctx, cp.LastID, opts.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(entities) == 0 {
+			break
+		}
+		// пакет сбрасывается на диск до отметки: после сбоя пакет может быть записан повторно,
+		// что при чтении файла равносильно одной записи
+		w := bufio.NewWriter(file)
+		if err = repository.WriteEntities(w, entities); err != nil {
+			return err
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+		if err = file.Sync(); err != nil {
+			return err
+		}
+		exported += len(entities)
+		cp.LastID = lastID
+		if err = cp.save(opts.checkpointName); err != nil {
+			return err
+		}
+		log.Printf("exported %d rows, last row %d\n", exported, lastID)
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d entities\n", exported)
+
+	// сверка: в файле столько же записей, сколько в БД
+	entities, stats, err := repository.ReadSnapshot(opts.fileName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("verified: %d entities in the file, %d in the database, %d corrupted records\n", len(entities), total, stats.Discarded)
+	if len(entities) != total || stats.Discarded > 0 {
+		return fmt.Errorf("file %s does not match the database", opts.fileName)
+	}
+	return os.Remove(opts.checkpointName)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"log"
+	"os"
+	"time"
+)
+
+// runImport загрузка записей файла в БД. Записи с уже существующим в БД ID или длинным URL
+// пользователя пропускаются, поэтому повторная загрузка ничего не меняет. Запись считается
+// перенесенной, только если в БД есть запись с тем же ID, пользователем и длинным URL
+func runImport(ctx context.Context, dbPool *db.T, opts options) error {
+	entities, stats, err := repository.ReadSnapshot(opts.fileName)
+	if err != nil {
+		return err
+	}
+	log.Printf("file %s: %d records, %d corrupted, %d entities\n", opts.fileName, stats.Records, stats.Discarded, len(entities))
+
+	entities, duplicates := dedup(entities)
+	for _, d := range duplicates {
+		fmt.Printf("duplicate\t%s\t%s\t%s dropped, %s kept\n", d.dropped.UserID, d.dropped.LongURL, d.dropped.ShortID, d.kept)
+	}
+	log.Printf("%d duplicate long URLs dropped, %d entities to import\n", len(duplicates), len(entities))
+	if n := stampDeletedAt(entities); n > 0 {
+		log.Printf("%d deleted entities without deletion time stamped with the import time\n", n)
+	}
+
+	if opts.dryRun {
+		c, err := compare(ctx, dbPool, entities, opts.batchSize)
+		if err != nil {
+			return err
+		}
+		c.printCollisions()
+		fmt.Printf("dry run: %d entities would be imported, %d already present, %d short ID collisions\n",
+			len(c.absent), c.present, len(c.collisions))
+		return nil
+	}
+
+	cp, err := loadCheckpoint(opts.checkpointName, "import")
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		cp = &checkpointT{Command: "import", Total: len(entities)}
+	} else if cp.Total != len(entities) {
+		return fmt.Errorf("file %s has changed since the interrupted import: %d entities, checkpoint has %d",
+			opts.fileName, len(entities), cp.Total)
+	} else {
+		log.Printf("resuming import from entity %d\n", cp.Offset)
+	}
+
+	inserted, skipped := 0, 0
+	for cp.Offset < len(entities) {
+		batch := entities[cp.Offset:batchEnd(cp.Offset, opts.batchSize, len(entities))]
+		n, err := dbPool.InsertEntities(ctx, batch)
+		if err != nil {
+			return err
+		}
+		inserted += n
+		skipped += len(batch) - n
+		cp.Offset += len(batch)
+		if err = cp.save(opts.checkpointName); err != nil {
+			return err
+		}
+		log.Printf("imported %d of %d\n", cp.Offset, len(entities))
+	}
+	fmt.Printf("imported %d entities, %d skipped as already existing\n", inserted, skipped)
+
+	// сверка: каждая запись файла есть в БД
+	c, err := compare(ctx, dbPool, entities, opts.batchSize)
+	if err != nil {
+		return err
+	}
+	for _, e := range c.absent {
+		fmt.Printf("missing\t%s\t%s\t%s\n", e.UserID, e.LongURL, e.ShortID)
+	}
+	c.printCollisions()
+	fmt.Printf("verified: %d of %d entities present in the database\n", c.present, len(entities))
+	if len(c.absent) > 0 || len(c.collisions) > 0 {
+		return fmt.Errorf("%d entities are missing: long URL is already shortened by the same user in the database; "+
+			"%d short IDs are taken by other records", len(c.absent), len(c.collisions))
+	}
+	return os.Remove(opts.checkpointName)
+}
+
+// stampDeletedAt записям, удаленным до появления времени удаления в файле, присваивается время загрузки,
+// как при миграции 0004 и загрузке файла сервисом: иначе их нельзя ни восстановить, ни окончательно удалить
+func stampDeletedAt(entities []db.Entity) int {
+	now := time.Now().UTC()
+	stamped := 0
+	for i := range entities {
+		if entities[i].Deleted && entities[i].DeletedAt == nil {
+			entities[i].DeletedAt = &now
+			stamped++
+		}
+	}
+	return stamped
+}
+
+type collision struct {
+	entity db.Entity
+	other  db.Entity // запись БД с тем же ID
+}
+
+// comparison сверка записей файла с БД
+type comparison struct {
+	present    int         // в БД есть запись с тем же ID, пользователем и длинным URL
+	absent     []db.Entity // ID в БД свободен
+	collisions []collision // ID в БД занят другой записью
+}
+
+func compare(ctx context.Context, dbPool *db.T, entities []db.Entity, batchSize int) (comparison, error) {
+	var c comparison
+	for offset := 0; offset < len(entities); offset += batchSize {
+		batch := entities[offset:batchEnd(offset, batchSize, len(entities))]
+		selection, err := dbPool.SelectByShortIDs(ctx, shortIDs(batch))
+		if err != nil {
+			return c, err
+		}
+		found := make(map[string]db.Entity, len(selection))
+		for _, e := range selection {
+			found[e.ShortID] = e
+		}
+		for _, e := range batch {
+			other, ok := found[e.ShortID]
+			switch {
+			case !ok:
+				c.absent = append(c.absent, e)
+			case other.UserID == e.UserID && other.LongURL == e.LongURL:
+				c.present++
+			default:
+				c.collisions = append(c.collisions, collision{entity: e, other: other})
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c comparison) printCollisions() {
+	for _, col := range c.collisions {
+		fmt.Printf("collision\t%s\t%s\t%s taken by %s\t%s\n",
+			col.entity.UserID, col.entity.LongURL, col.entity.ShortID, col.other.UserID, col.other.LongURL)
+	}
+}
+
+type duplicate struct {
+	dropped db.Entity
+	kept    string // ID оставленной записи
+}
+
+// dedup удаление повторов длинного URL пользователя: остается первая запись.
+// Такие повторы возможны в файлах, записанных до проверки уникальности
+func dedup(entities []db.Entity) ([]db.Entity, []duplicate) {
+	unique := make([]db.Entity, 0, len(entities))
+	seen := make(map[string]string, len(entities))
+	var duplicates []duplicate
+	for _, e := range entities {
+		key := e.UserID + "\x00" + e.LongURL
+		if kept, ok := seen[key]; ok {
+			duplicates = append(duplicates, duplicate{dropped: e, kept: kept})
+			continue
+		}
+		seen[key] = e.ShortID
+		unique = append(unique, e)
+	}
+	return unique, duplicates
+}
+
+func shortIDs(entities []db.Entity) []string {
+	ids := make([]string, len(entities))
+	for i, e := range entities {
+		ids[i] = e.ShortID
+	}
+	return ids
+}
+
+func batchEnd(offset, batchSize, total int) int {
+	if offset+batchSize > total {
+		return total
+	}
+	return offset + batchSize
+}
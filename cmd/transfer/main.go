@@ -0,0 +1,119 @@
+// Команда transfer - перенос данных между файлом хранилища и Postgres при остановленном сервисе:
+//
+//	transfer -d <postgres url> -f <файл> import  загрузка записей файла в БД
+//	transfer -d <postgres url> -f <файл> export  выгрузка записей БД в новый файл
+//
+// Флаги:
+//
+//	-dry-run     только проверка и отчет, без записи
+//	-batch n     записей в одном пакете (по умолчанию 1000)
+//	-checkpoint  файл с отметкой о ходе переноса (по умолчанию <файл>.transfer).
+//	             Прерванный перенос продолжается с отметки при повторном запуске
+//
+// Если флаги -d и -f не заданы, используются переменные окружения DATABASE_DSN и FILE_STORAGE_PATH.
+// В конце переноса количество записей в источнике и приемнике сверяется
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"log"
+	"os"
+)
+
+type options struct {
+	fileName       string
+	checkpointName string
+	batchSize      int
+	dryRun         bool
+}
+
+func main() {
+	dsn := flag.String("d", os.Getenv("DATABASE_DSN"), "postgres url")
+	fileName := flag.String("f", os.Getenv("FILE_STORAGE_PATH"), "path to storage file")
+	dryRun := flag.Bool("dry-run", false, "report only, do not write")
+	batchSize := flag.Int("batch", 1000, "records per batch")
+	checkpoint := flag.String("checkpoint", "", "checkpoint file to resume an interrupted transfer")
+	flag.Parse()
+	if *dsn == "" {
+		log.Fatal("postgres url is not set: use -d or DATABASE_DSN")
+	}
+	if *fileName == "" {
+		log.Fatal("storage file is not set: use -f or FILE_STORAGE_PATH")
+	}
+	if *batchSize <= 0 {
+		log.Fatalf("bad batch size %d", *batchSize)
+	}
+	opts := options{fileName: *fileName, checkpointName: *checkpoint, batchSize: *batchSize, dryRun: *dryRun}
+	if opts.checkpointName == "" {
+		opts.checkpointName = opts.fileName + ".transfer"
+	}
+
+	// без -dry-run схема БД приводится к последней версии
+	ctx := context.Background()
+	connect := db.New
+	if opts.dryRun {
+		connect = db.Connect
+	}
+	dbPool, err := connect(ctx, *dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dbPool.Close()
+
+	switch command := flag.Arg(0); command {
+	case "import":
+		err = runImport(ctx, &dbPool, opts)
+	case "export":
+		err = runExport(ctx, &dbPool, opts)
+	default:
+		err = fmt.Errorf("unknown command %q: use import or export", command)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkpointT отметка о ходе переноса, сохраняется после каждого пакета
+type checkpointT struct {
+	Command string `json:"command"`
+	Total   int    `json:"total"`   // import: записей к загрузке, для проверки, что файл не изменился
+	Offset  int    `json:"offset"`  // import: загружено записей
+	LastID  int64  `json:"last_id"` // export: номер последней выгруженной строки БД
+}
+
+// loadCheckpoint отметка прерванного переноса; nil, если перенос не начинался
+func loadCheckpoint(fileName string, command string) (*checkpointT, error) {
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpointT
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", fileName, err)
+	}
+	if cp.Command != command {
+		return nil, fmt.Errorf("checkpoint %s belongs to %s, not %s", fileName, cp.Command, command)
+	}
+	return &cp, nil
+}
+
+// save запись отметки через временный файл, чтобы сбой не оставил поврежденную отметку
+func (cp *checkpointT) save(fileName string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := fileName + ".tmp"
+	if err = os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
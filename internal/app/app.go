@@ -2,11 +2,13 @@ package app
 
 import (
 	"context"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cache"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/policy"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
-	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/purge"
 	"log"
 	"net"
 	"net/http"
@@ -16,6 +18,9 @@ import (
 	"time"
 )
 
+// listenerReadyTimeout ожидание подписки на оповещения при запуске
+const listenerReadyTimeout = 5 * time.Second
+
 func Run() {
 	var cfgApp, err = cfg.New()
 	if err != nil {
@@ -25,29 +30,69 @@ func Run() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// database
-	dbPool, err := db.New(ctx, cfgApp.DatabaseDSN)
+	// хранилище: postgres, файл или оперативная память
+	repo, closeRepo, err := newRepository(ctx, cfgApp)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer dbPool.Close()
-
-	// file&map repository
-	fileRepo, err := repository.New(cfgApp.FileStoragePath)
+	cfgApp.IDGenerator, err = newIDGenerator(cfgApp, repo)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer fileRepo.Close()
+	if cfgApp.PolicyFile != "" {
+		if cfgApp.Policy, err = policy.New(cfgApp.PolicyFile, cfgApp.PolicyReloadInterval); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	repo := &dbPool
-	//repo := fileRepo
+	// кэш перехода по короткой ссылке. Изменения записей в Postgres другими экземплярами сервиса
+	// приходят оповещениями; пропущенные за время без соединения оповещения сбрасывают весь кэш
+	var listener *db.Listener
+	if cfgApp.CacheSize > 0 {
+		repoCache := cache.New(repo, cache.Options{
+			Capacity:    cfgApp.CacheSize,
+			TTL:         cfgApp.CacheTTL,
+			NegativeTTL: cfgApp.CacheNegativeTTL,
+		})
+		if cfgApp.DatabaseDSN != "" {
+			listener = db.NewListener(cfgApp.DatabaseDSN, db.ListenerOptions{
+				OnChange:    func(shortID string) { repoCache.Invalidate(shortID) },
+				OnReconnect: repoCache.Clear,
+			})
+			// без подписки изменения других экземпляров видны только по истечении CacheTTL
+			select {
+			case <-listener.Ready():
+			case <-time.After(listenerReadyTimeout):
+				log.Printf("listen %s: not subscribed in %v, continuing without notifications\n",
+					db.ChangesChannel, listenerReadyTimeout)
+			}
+		}
+		repo = repoCache
+	}
 
 	// repository pool for delete items (set flag "deleted")
-	deleterPool := pool.New(ctx, repo)
-	defer deleterPool.Close()
-	cfgApp.DeleterChan = deleterPool.Input
+	deleterPool := pool.NewWithOptions(ctx, repo, pool.Options{
+		Workers:       cfgApp.DeleteWorkers,
+		BufferSize:    cfgApp.DeleteBufferSize,
+		BatchSize:     cfgApp.DeleteBatchSize,
+		FlushInterval: cfgApp.DeleteFlushInterval,
+		MaxAttempts:   cfgApp.DeleteMaxAttempts,
+		RetryDelay:    cfgApp.DeleteRetryDelay,
+		RetryMaxDelay: cfgApp.DeleteRetryMaxDelay,
+		IsTransient:   db.IsTransient,
+	})
+	cfgApp.DeleterPool = deleterPool
+
+	// окончательное удаление записей с истекшим сроком хранения
+	var purgeJob *purge.T
+	if purger, ok := repo.(purge.Purger); ok && cfgApp.PurgeInterval > 0 && cfgApp.DeleteGracePeriod > 0 {
+		purgeJob = purge.New(purger, purge.Options{
+			Interval:  cfgApp.PurgeInterval,
+			Retention: cfgApp.DeleteGracePeriod,
+			BatchSize: cfgApp.PurgeBatchSize,
+		})
+	}
 
-	//r := handlers.NewRouter(repo, cfgApp)
 	r := handlers.NewRouter(repo, cfgApp)
 	httpServer := &http.Server{
 		Addr:        cfgApp.ServerAddress,
@@ -69,16 +114,13 @@ func Run() {
 		syscall.SIGHUP,  // kill -SIGHUP XXXX
 		syscall.SIGINT,  // kill -SIGINT XXXX or Ctrl+c
 		syscall.SIGQUIT, // kill -SIGQUIT XXXX
+		syscall.SIGTERM, // kill XXXX, остановка контейнера
 	)
 
-	select {
-	case <-signalChan:
-		log.Println("os.Interrupt - shutting down...")
-	case err := <-deleterPool.ErrCh:
-		log.Println(err)
-	}
-	cancel()
+	sig := <-signalChan
+	log.Printf("%v - shutting down...\n", sig)
 
+	// остановка по порядку: прием запросов, пул удаления, хранилище
 	gracefulCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelShutdown()
 
@@ -87,4 +129,20 @@ func Run() {
 	} else {
 		log.Printf("web server gracefully stopped\n")
 	}
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfgApp.DeleteDrainTimeout)
+	defer cancelDrain()
+	deleterPool.Shutdown(drainCtx)
+	if purgeJob != nil {
+		purgeJob.Close()
+	}
+	if listener != nil {
+		listener.Close()
+	}
+	if cfgApp.Policy != nil {
+		cfgApp.Policy.Close()
+	}
+
+	closeRepo()
+	log.Println("storage closed")
 }
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type aliasRequest struct {
+	URL   string `json:"url"`
+	Alias string `json:"alias,omitempty"`
+}
+
+type aliasBatchItem struct {
+	CorrelationID string `json:"correlation_id"`
+	OriginalURL   string `json:"original_url"`
+	Alias         string `json:"alias,omitempty"`
+}
+
+func TestAliasAPI(t *testing.T) {
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+	r := handlers.NewRouter(repo, cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	shorten := func(alias string, cookies []*http.Cookie) (*http.Response, string) {
+		body, err := json.Marshal(aliasRequest{URL: "https://yandex.ru/" + uuid.NewString(), Alias: alias})
+		require.NoError(t, err)
+		resp, respBody := testGZipRequestCookie(t, ts.URL+"/api/shorten", http.MethodPost, strings.NewReader(string(body)), cookies)
+		require.NoError(t, resp.Body.Close())
+		return resp, respBody
+	}
+
+	// короткий URL с выбранным ID
+	resp, body := shorten("spring-sale", nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, *BaseURL+"/spring-sale", testDecodeJSONShortURL(t, body))
+	cookies := resp.Cookies()
+
+	resp, _ = testRequest(t, ts.URL+"/spring-sale", http.MethodGet, nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	// занятый ID - 409
+	resp, _ = shorten("spring-sale", nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// недопустимые символы, длина и зарезервированные слова - 400
+	for _, alias := range []string{"spring sale", "ok", strings.Repeat("a", 65), "распродажа", "API"} {
+		resp, _ = shorten(alias, nil)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, alias)
+	}
+	// зарезервированы первые сегменты всех путей роутера
+	err = chi.Walk(r, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		segment := strings.SplitN(strings.TrimPrefix(route, "/"), "/", 2)[0]
+		if segment == "" || strings.HasPrefix(segment, "{") {
+			return nil
+		}
+		resp, _ := shorten(segment, nil)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, route)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// пакет: выбранный ID у части элементов
+	batch, err := json.Marshal([]aliasBatchItem{
+		{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString(), Alias: "summer_sale"},
+		{CorrelationID: "1", OriginalURL: "https://yandex.ru/" + uuid.NewString()},
+	})
+	require.NoError(t, err)
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten/batch", http.MethodPost, strings.NewReader(string(batch)), cookies)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var out batchOutput
+	require.NoError(t, json.Unmarshal([]byte(body), &out))
+	require.Equal(t, *BaseURL+"/summer_sale", out[0].ShortURL)
+
+	// пакет с занятым ID не добавляется
+	batch, err = json.Marshal([]aliasBatchItem{
+		{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString()},
+		{CorrelationID: "1", OriginalURL: "https://yandex.ru/" + uuid.NewString(), Alias: "spring-sale"},
+	})
+	require.NoError(t, err)
+	resp, _ = testGZipRequestCookie(t, ts.URL+"/api/shorten/batch", http.MethodPost, strings.NewReader(string(batch)), cookies)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// выбранный ID не применяется к уже сокращенному URL, причина - в ответе
+	longURL := "https://yandex.ru/" + uuid.NewString()
+	body = `{"url":"` + longURL + `"}`
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten", http.MethodPost, strings.NewReader(body), cookies)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	existing := testDecodeJSONShortURL(t, body)
+
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten", http.MethodPost,
+		strings.NewReader(`{"url":"`+longURL+`","alias":"autumn-sale"}`), cookies)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, existing, testDecodeJSONShortURL(t, body))
+	require.Contains(t, body, `alias \"autumn-sale\" is not applied`)
+
+	batch, err = json.Marshal([]aliasBatchItem{
+		{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString(), Alias: "winter-sale"},
+		{CorrelationID: "1", OriginalURL: longURL, Alias: "autumn-sale"},
+	})
+	require.NoError(t, err)
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten/batch", http.MethodPost, strings.NewReader(string(batch)), cookies)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	out = nil
+	require.NoError(t, json.Unmarshal([]byte(body), &out))
+	require.Empty(t, out[0].Error)
+	require.Equal(t, existing, out[1].ShortURL)
+	require.Contains(t, out[1].Error, `alias "autumn-sale" is not applied`)
+
+	// выбранные ID в истории пользователя
+	resp, body = testGZipRequestCookie(t, ts.URL+"/user/urls", http.MethodGet, strings.NewReader(""), cookies)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, *BaseURL+"/spring-sale")
+	require.Contains(t, body, *BaseURL+"/summer_sale")
+	require.Equal(t, 5, strings.Count(body, "short_url"))
+}
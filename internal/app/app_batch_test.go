@@ -0,0 +1,62 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBatchAPIDuplicates(t *testing.T) {
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout}
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	r := handlers.NewRouter(repo, cfgApp)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// все URL новые - 201
+	url1, url2 := "https://yandex.ru/"+uuid.NewString(), "https://yandex.ru/"+uuid.NewString()
+	resp, out := testBatchRequest(t, ts.URL, batchInput{{CorrelationID: "0", OriginalURL: url1}}, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	require.Equal(t, "created", out[0].Status)
+	shortURL1 := out[0].ShortURL
+
+	// часть URL существует - 207, для существующих возвращается прежний короткий URL
+	resp, out = testBatchRequest(t, ts.URL, batchInput{
+		{CorrelationID: "0", OriginalURL: url1},
+		{CorrelationID: "1", OriginalURL: url2},
+	}, cookies)
+	require.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	require.Equal(t, batchOutputItem{CorrelationID: "0", ShortURL: shortURL1, Status: "exists"}, out[0])
+	require.Equal(t, "created", out[1].Status)
+
+	// все URL существуют - 409
+	resp, out = testBatchRequest(t, ts.URL, batchInput{{CorrelationID: "0", OriginalURL: url2}}, cookies)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, "exists", out[0].Status)
+
+	// URL, сокращенные другим пользователем, не считаются дубликатами
+	resp, out = testBatchRequest(t, ts.URL, batchInput{{CorrelationID: "0", OriginalURL: url2}}, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "created", out[0].Status)
+}
+
+func testBatchRequest(t *testing.T, url string, batch batchInput, cookies []*http.Cookie) (*http.Response, batchOutput) {
+	jsonBatch, err := json.Marshal(batch)
+	require.NoError(t, err)
+	resp, body := testGZipRequestCookie(t, url+"/api/shorten/batch", http.MethodPost, bytes.NewBuffer(jsonBatch), cookies)
+	var out batchOutput
+	require.NoError(t, json.Unmarshal([]byte(body), &out))
+	require.Len(t, out, len(batch))
+	return resp, out
+}
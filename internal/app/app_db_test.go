@@ -4,11 +4,14 @@ type batchInput []batchInputItem
 type batchInputItem struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	Alias         string `json:"alias,omitempty"`
 }
 type batchOutput []batchOutputItem
 type batchOutputItem struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
 }
 
 /*
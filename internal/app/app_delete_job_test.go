@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type deleteJobResponse struct {
+	JobID string `json:"job_id"`
+}
+
+type deleteJobStatus struct {
+	JobID  string `json:"job_id"`
+	Queued int    `json:"queued"`
+	Done   int    `json:"done"`
+	Failed int    `json:"failed"`
+	Items  []struct {
+		ShortID string `json:"id"`
+		Status  string `json:"status"`
+	} `json:"items"`
+}
+
+func TestDeleteJobAPI(t *testing.T) {
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+	deleterPool := pool.NewWithOptions(context.Background(), repo, pool.Options{FlushInterval: 10 * time.Millisecond})
+	defer deleterPool.Close()
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout, DeleterPool: deleterPool}
+
+	ts := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts.Close()
+
+	resp, out := testBatchRequest(t, ts.URL, batchInput{{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString()}}, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	u, err := url.Parse(out[0].ShortURL)
+	require.NoError(t, err)
+	shortID := u.Path[1:]
+
+	// удаление возвращает ID задания
+	resp, body := testGZipRequestCookie(t, ts.URL+"/api/user/urls", http.MethodDelete, testEncodeJSONDeleteList(shortIDList{shortID}), cookies)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var job deleteJobResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	require.NotEmpty(t, job.JobID)
+
+	// ход удаления доступен владельцу
+	require.Eventually(t, func() bool {
+		resp, body := testGZipRequestCookie(t, ts.URL+"/api/user/urls/jobs/"+job.JobID, http.MethodGet, http.NoBody, cookies)
+		var status deleteJobStatus
+		if resp.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &status) != nil {
+			return false
+		}
+		return status.Done == 1 && status.Queued == 0 && status.Items[0].ShortID == shortID && status.Items[0].Status == "done"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	resp, _ = testGZipRequestCookie(t, ts.URL+"/api/user/urls/jobs/"+job.JobID, http.MethodGet, http.NoBody, nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// stuckDeleter хранилище, удаление в котором не завершается до остановки пула
+type stuckDeleter struct{}
+
+func (stuckDeleter) SetDeletedBatch(ctx context.Context, _ string, _ []string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDeleteQueueFull(t *testing.T) {
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deleterPool := pool.NewWithOptions(ctx, stuckDeleter{}, pool.Options{Workers: 1, BufferSize: 1, BatchSize: 1})
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout, DeleterPool: deleterPool, DeleteFlushInterval: 2 * time.Second}
+
+	ts := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts.Close()
+
+	// переполненная очередь не блокирует запрос
+	var resp *http.Response
+	for i := 0; i < 5; i++ {
+		resp, _ = testGZipRequestCookie(t, ts.URL+"/api/user/urls", http.MethodDelete, testEncodeJSONDeleteList(shortIDList{uuid.NewString()}), nil)
+		if resp.StatusCode != http.StatusAccepted {
+			break
+		}
+	}
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	// запрос больше очереди не будет принят никогда
+	resp, _ = testGZipRequestCookie(t, ts.URL+"/api/user/urls", http.MethodDelete, testEncodeJSONDeleteList(shortIDList{"1", "2"}), nil)
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestRestoreAPI(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+	repo, err := repository.New(fileName)
+	require.NoError(t, err)
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout, DeleteGracePeriod: time.Hour}
+	ts := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts.Close()
+
+	resp, out := testBatchRequest(t, ts.URL, batchInput{{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString()}}, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+	u, err := url.Parse(out[0].ShortURL)
+	require.NoError(t, err)
+	shortID := u.Path[1:]
+	require.NoError(t, repo.SetDeletedBatch(context.Background(), testOwner(t, repo, shortID), shortIDList{shortID}))
+
+	// восстановить может только владелец
+	resp, body := testGZipRequestCookie(t, ts.URL+"/api/user/urls/restore", http.MethodPost, testEncodeJSONDeleteList(shortIDList{shortID}), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `[]`, body)
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/user/urls/restore", http.MethodPost, testEncodeJSONDeleteList(shortIDList{shortID}), cookies)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, `["`+shortID+`"]`, body)
+
+	// восстановление сохраняется в файле
+	repo.Close()
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	defer repo.Close()
+	entity, err := repo.SelectByShortID(context.Background(), shortID)
+	require.NoError(t, err)
+	require.False(t, entity.Deleted)
+	require.Nil(t, entity.DeletedAt)
+}
+
+// testOwner владелец записи
+func testOwner(t *testing.T, repo handlers.Repositorier, shortID string) string {
+	entity, err := repo.SelectByShortID(context.Background(), shortID)
+	require.NoError(t, err)
+	return entity.UserID
+}
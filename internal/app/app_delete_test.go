@@ -56,19 +56,13 @@ func TestDBDeleteBatch(t *testing.T) {
 	// пул горутин на удаление записей
 	deleterPool := pool.New(context.Background(), &dbPool)
 	//defer deleterPool.Close()
-	cfgApp.DeleterChan = deleterPool.Input
+	cfgApp.DeleterPool = deleterPool
 	r := handlers.NewRouter(&dbPool, cfgApp)
 	ts := httptest.NewServer(r)
 	defer ts.Close()
 
 	// создание таблицы
-	sql1 := "create table if not exists urls (" +
-		"id serial primary key, " +
-		"deleted boolean not null," +
-		"user_id varchar(512) not null, " +
-		"short_id varchar(512) not null unique, " +
-		"long_url varchar(1024) not null unique)"
-	_, err = dbPool.Exec(ctx, sql1)
+	_, err = dbPool.MigrateUp(ctx, 0)
 	require.NoError(t, err)
 
 	// запись в БД
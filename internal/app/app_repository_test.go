@@ -0,0 +1,395 @@
+package app
+
+import (
+	"context"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cache"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/dualwrite"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/purge"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newRepoFunc конструктор проверяемого хранилища
+type newRepoFunc func(t *testing.T) handlers.Repositorier
+
+func TestRepositoryFile(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) handlers.Repositorier {
+		repo, err := repository.New(filepath.Join(t.TempDir(), "storage.txt"))
+		require.NoError(t, err)
+		t.Cleanup(repo.Close)
+		return repo
+	})
+}
+
+func TestRepositoryMemory(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) handlers.Repositorier {
+		repo, err := repository.New("")
+		require.NoError(t, err)
+		t.Cleanup(repo.Close)
+		return repo
+	})
+}
+
+func TestRepositoryDualWrite(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) handlers.Repositorier {
+		primary, err := repository.New(filepath.Join(t.TempDir(), "storage.txt"))
+		require.NoError(t, err)
+		t.Cleanup(primary.Close)
+		secondary, err := repository.New("")
+		require.NoError(t, err)
+		t.Cleanup(secondary.Close)
+		return dualwrite.New(primary, secondary, dualwrite.Options{Fallback: true, CompareReads: true})
+	})
+}
+
+func TestRepositoryCache(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) handlers.Repositorier {
+		repo, err := repository.New(filepath.Join(t.TempDir(), "storage.txt"))
+		require.NoError(t, err)
+		t.Cleanup(repo.Close)
+		return cache.New(repo, cache.Options{Capacity: 100, TTL: time.Hour, NegativeTTL: time.Hour})
+	})
+}
+
+func TestRepositoryPostgres(t *testing.T) {
+	if *DatabaseDSN == "" {
+		t.Skip("postgres url is not set (-d)")
+	}
+	testRepositoryConformance(t, func(t *testing.T) handlers.Repositorier {
+		dbPool, err := db.New(context.Background(), *DatabaseDSN)
+		require.NoError(t, err)
+		t.Cleanup(dbPool.Close)
+		return &dbPool
+	})
+}
+
+// TestCacheNotify добавление и удаление записей другим экземпляром сервиса сбрасывают их в кэше
+func TestCacheNotify(t *testing.T) {
+	if *DatabaseDSN == "" {
+		t.Skip("postgres url is not set (-d)")
+	}
+	ctx := context.Background()
+	local, err := db.New(ctx, *DatabaseDSN)
+	require.NoError(t, err)
+	defer local.Close()
+	remote, err := db.Connect(ctx, *DatabaseDSN)
+	require.NoError(t, err)
+	defer remote.Close()
+
+	repo := cache.New(&local, cache.Options{Capacity: 100, TTL: time.Hour, NegativeTTL: time.Hour})
+	listener := db.NewListener(*DatabaseDSN, db.ListenerOptions{
+		OnChange: func(shortID string) { repo.Invalidate(shortID) },
+	})
+	defer listener.Close()
+	select {
+	case <-listener.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener is not subscribed")
+	}
+
+	e := db.Entity{UserID: uuid.NewString(), ShortID: uuid.NewString(), LongURL: "https://yandex.ru/" + uuid.NewString()}
+	require.NoError(t, repo.AddEntity(ctx, e))
+	got, err := repo.SelectByShortID(ctx, e.ShortID)
+	require.NoError(t, err)
+	require.False(t, got.Deleted)
+
+	// добавление записи другим экземпляром сбрасывает отметку об ее отсутствии
+	added := db.Entity{UserID: e.UserID, ShortID: uuid.NewString(), LongURL: "https://yandex.ru/" + uuid.NewString()}
+	_, err = repo.SelectByShortID(ctx, added.ShortID)
+	require.ErrorIs(t, err, db.ErrNotFound)
+	require.NoError(t, remote.AddEntity(ctx, added))
+	require.Eventually(t, func() bool {
+		_, err := repo.SelectByShortID(ctx, added.ShortID)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// удаление записи другим экземпляром сбрасывает ее в кэше
+	require.NoError(t, remote.SetDeletedBatch(ctx, e.UserID, []string{e.ShortID}))
+	require.Eventually(t, func() bool {
+		got, err := repo.SelectByShortID(ctx, e.ShortID)
+		return err == nil && got.Deleted
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// testRepositoryConformance общий набор проверок для всех реализаций handlers.Repositorier.
+// Идентификаторы и URL случайные, поэтому хранилище может быть не пустым
+func testRepositoryConformance(t *testing.T, newRepo newRepoFunc) {
+	ctx := context.Background()
+
+	newEntity := func(userID string) db.Entity {
+		return db.Entity{
+			UserID:  userID,
+			ShortID: uuid.NewString(),
+			LongURL: "https://yandex.ru/" + uuid.NewString(),
+		}
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		repo := newRepo(t)
+		e := newEntity(uuid.NewString())
+		require.NoError(t, repo.AddEntity(ctx, e))
+
+		got, err := repo.SelectByShortID(ctx, e.ShortID)
+		require.NoError(t, err)
+		require.Equal(t, e, got)
+
+		got, err = repo.SelectByLongURL(ctx, e.UserID, e.LongURL)
+		require.NoError(t, err)
+		require.Equal(t, e, got)
+
+		require.NoError(t, repo.Ping(ctx))
+	})
+
+	t.Run("dedup", func(t *testing.T) {
+		repo := newRepo(t)
+		e := newEntity(uuid.NewString())
+		require.NoError(t, repo.AddEntity(ctx, e))
+
+		dup := newEntity(e.UserID)
+		dup.LongURL = e.LongURL
+		require.ErrorIs(t, repo.AddEntity(ctx, dup), db.ErrUniqueViolation)
+
+		got, err := repo.SelectByLongURL(ctx, e.UserID, e.LongURL)
+		require.NoError(t, err)
+		require.Equal(t, e.ShortID, got.ShortID)
+
+		_, err = repo.SelectByShortID(ctx, dup.ShortID)
+		require.ErrorIs(t, err, db.ErrNotFound)
+	})
+
+	t.Run("dedup per user", func(t *testing.T) {
+		repo := newRepo(t)
+		e := newEntity(uuid.NewString())
+		require.NoError(t, repo.AddEntity(ctx, e))
+
+		// тот же URL другого пользователя - отдельная запись, которой он владеет
+		other := newEntity(uuid.NewString())
+		other.LongURL = e.LongURL
+		require.NoError(t, repo.AddEntity(ctx, other))
+
+		got, err := repo.SelectByLongURL(ctx, other.UserID, other.LongURL)
+		require.NoError(t, err)
+		require.Equal(t, other, got)
+		selection, err := repo.SelectByUser(ctx, other.UserID)
+		require.NoError(t, err)
+		require.Equal(t, []db.Entity{other}, selection)
+
+		_, err = repo.SelectByLongURL(ctx, uuid.NewString(), e.LongURL)
+		require.ErrorIs(t, err, db.ErrNotFound)
+
+		batch := db.BatchInput{{CorrelationID: "0", OriginalURL: e.LongURL, ShortID: uuid.NewString()}}
+		require.NoError(t, repo.AddEntityBatch(ctx, uuid.NewString(), batch))
+		require.Equal(t, db.BatchCreated, batch[0].Status)
+	})
+
+	t.Run("short ID taken", func(t *testing.T) {
+		repo := newRepo(t)
+		e := newEntity(uuid.NewString())
+		require.NoError(t, repo.AddEntity(ctx, e))
+
+		other := newEntity(uuid.NewString())
+		other.ShortID = e.ShortID
+		require.ErrorIs(t, repo.AddEntity(ctx, other), db.ErrShortIDTaken)
+
+		// пакет с занятым ID не добавляется целиком
+		userID := uuid.NewString()
+		batch := db.BatchInput{
+			{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString(), ShortID: uuid.NewString()},
+			{CorrelationID: "1", OriginalURL: "https://yandex.ru/" + uuid.NewString(), ShortID: e.ShortID},
+		}
+		require.ErrorIs(t, repo.AddEntityBatch(ctx, userID, batch), db.ErrShortIDTaken)
+		selection, err := repo.SelectByUser(ctx, userID)
+		require.NoError(t, err)
+		require.Empty(t, selection)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		repo := newRepo(t)
+		userID := uuid.NewString()
+		batch := db.BatchInput{
+			{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString(), ShortID: uuid.NewString()},
+			{CorrelationID: "1", OriginalURL: "https://yandex.ru/" + uuid.NewString(), ShortID: uuid.NewString()},
+		}
+		require.NoError(t, repo.AddEntityBatch(ctx, userID, batch))
+		for _, v := range batch {
+			require.Equal(t, db.BatchCreated, v.Status)
+			got, err := repo.SelectByShortID(ctx, v.ShortID)
+			require.NoError(t, err)
+			require.Equal(t, db.Entity{UserID: userID, ShortID: v.ShortID, LongURL: v.OriginalURL}, got)
+		}
+	})
+
+	t.Run("batch duplicates", func(t *testing.T) {
+		repo := newRepo(t)
+		userID := uuid.NewString()
+		e := newEntity(userID)
+		require.NoError(t, repo.AddEntity(ctx, e))
+
+		// дубликат существующей записи и дубликат внутри пакета получают ID существующих записей
+		newURL := "https://yandex.ru/" + uuid.NewString()
+		batch := db.BatchInput{
+			{CorrelationID: "0", OriginalURL: newURL, ShortID: uuid.NewString()},
+			{CorrelationID: "1", OriginalURL: e.LongURL, ShortID: uuid.NewString()},
+			{CorrelationID: "2", OriginalURL: newURL, ShortID: uuid.NewString()},
+		}
+		dupShortID := batch[1].ShortID
+		require.NoError(t, repo.AddEntityBatch(ctx, userID, batch))
+
+		require.Equal(t, db.BatchCreated, batch[0].Status)
+		require.Equal(t, db.BatchExists, batch[1].Status)
+		require.Equal(t, e.ShortID, batch[1].ShortID)
+		require.Equal(t, db.BatchExists, batch[2].Status)
+		require.Equal(t, batch[0].ShortID, batch[2].ShortID)
+
+		got, err := repo.SelectByLongURL(ctx, userID, newURL)
+		require.NoError(t, err)
+		require.Equal(t, batch[0].ShortID, got.ShortID)
+		_, err = repo.SelectByShortID(ctx, dupShortID)
+		require.ErrorIs(t, err, db.ErrNotFound)
+	})
+
+	t.Run("select by user", func(t *testing.T) {
+		repo := newRepo(t)
+		userA, userB := uuid.NewString(), uuid.NewString()
+		entitiesA := []db.Entity{newEntity(userA), newEntity(userA)}
+		for _, e := range entitiesA {
+			require.NoError(t, repo.AddEntity(ctx, e))
+		}
+		require.NoError(t, repo.AddEntity(ctx, newEntity(userB)))
+
+		selection, err := repo.SelectByUser(ctx, userA)
+		require.NoError(t, err)
+		require.ElementsMatch(t, entitiesA, selection)
+
+		selection, err = repo.SelectByUser(ctx, uuid.NewString())
+		require.NoError(t, err)
+		require.Empty(t, selection)
+	})
+
+	t.Run("select by short IDs", func(t *testing.T) {
+		repo := newRepo(t)
+		a, b := newEntity(uuid.NewString()), newEntity(uuid.NewString())
+		require.NoError(t, repo.AddEntity(ctx, a))
+		require.NoError(t, repo.AddEntity(ctx, b))
+
+		selection, err := repo.SelectByShortIDs(ctx, []string{a.ShortID, uuid.NewString(), b.ShortID})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []db.Entity{a, b}, selection)
+
+		selection, err = repo.SelectByShortIDs(ctx, []string{uuid.NewString()})
+		require.NoError(t, err)
+		require.Empty(t, selection)
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		repo := newRepo(t)
+		owner, stranger := uuid.NewString(), uuid.NewString()
+		e1, e2, e3 := newEntity(owner), newEntity(owner), newEntity(owner)
+		for _, e := range []db.Entity{e1, e2, e3} {
+			require.NoError(t, repo.AddEntity(ctx, e))
+		}
+
+		// чужой пользователь не может удалить запись
+		require.NoError(t, repo.SetDeleted(ctx, pool.ToDeleteItem{UserID: stranger, ShortID: e1.ShortID}))
+		require.NoError(t, repo.SetDeletedBatch(ctx, stranger, []string{e2.ShortID, e3.ShortID}))
+		for _, e := range []db.Entity{e1, e2, e3} {
+			got, err := repo.SelectByShortID(ctx, e.ShortID)
+			require.NoError(t, err)
+			require.False(t, got.Deleted)
+		}
+
+		// владелец удаляет; несуществующие ID пропускаются
+		require.NoError(t, repo.SetDeleted(ctx, pool.ToDeleteItem{UserID: owner, ShortID: e1.ShortID}))
+		require.NoError(t, repo.SetDeletedBatch(ctx, owner, []string{e2.ShortID, uuid.NewString()}))
+		for _, e := range []db.Entity{e1, e2} {
+			got, err := repo.SelectByShortID(ctx, e.ShortID)
+			require.NoError(t, err)
+			require.True(t, got.Deleted)
+		}
+		got, err := repo.SelectByShortID(ctx, e3.ShortID)
+		require.NoError(t, err)
+		require.False(t, got.Deleted)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		repo := newRepo(t)
+		owner := uuid.NewString()
+		e1, e2, e3 := newEntity(owner), newEntity(owner), newEntity(owner)
+		for _, e := range []db.Entity{e1, e2, e3} {
+			require.NoError(t, repo.AddEntity(ctx, e))
+		}
+		require.NoError(t, repo.SetDeletedBatch(ctx, owner, []string{e1.ShortID, e2.ShortID}))
+		got, err := repo.SelectByShortID(ctx, e1.ShortID)
+		require.NoError(t, err)
+		require.NotNil(t, got.DeletedAt)
+
+		// чужой пользователь не может восстановить запись; запись с истекшим сроком не восстанавливается
+		restored, err := repo.RestoreBatch(ctx, uuid.NewString(), []string{e1.ShortID}, time.Time{})
+		require.NoError(t, err)
+		require.Empty(t, restored)
+		restored, err = repo.RestoreBatch(ctx, owner, []string{e2.ShortID}, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, restored)
+
+		// неудаленные и несуществующие записи пропускаются
+		restored, err = repo.RestoreBatch(ctx, owner, []string{e1.ShortID, e3.ShortID, uuid.NewString()}, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, []string{e1.ShortID}, restored)
+		got, err = repo.SelectByShortID(ctx, e1.ShortID)
+		require.NoError(t, err)
+		require.Equal(t, e1, got)
+		got, err = repo.SelectByShortID(ctx, e2.ShortID)
+		require.NoError(t, err)
+		require.True(t, got.Deleted)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		repo := newRepo(t)
+		purger := repo.(purge.Purger)
+		owner := uuid.NewString()
+		deleted, kept := newEntity(owner), newEntity(owner)
+		for _, e := range []db.Entity{deleted, kept} {
+			require.NoError(t, repo.AddEntity(ctx, e))
+		}
+		require.NoError(t, repo.SetDeletedBatch(ctx, owner, []string{deleted.ShortID}))
+
+		// запись, удаленная позже deletedBefore, не удаляется окончательно
+		_, err := purger.PurgeDeleted(ctx, time.Now().Add(-time.Hour), 100)
+		require.NoError(t, err)
+		_, err = repo.SelectByShortID(ctx, deleted.ShortID)
+		require.NoError(t, err)
+
+		// хранилище может быть не пустым, поэтому удаление пакетами до конца
+		for {
+			n, err := purger.PurgeDeleted(ctx, time.Now().Add(time.Second), 100)
+			require.NoError(t, err)
+			if n < 100 {
+				break
+			}
+		}
+		_, err = repo.SelectByShortID(ctx, deleted.ShortID)
+		require.ErrorIs(t, err, db.ErrNotFound)
+		_, err = repo.SelectByShortID(ctx, kept.ShortID)
+		require.NoError(t, err)
+
+		// длинный URL снова можно сократить
+		again := newEntity(owner)
+		again.LongURL = deleted.LongURL
+		require.NoError(t, repo.AddEntity(ctx, again))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.SelectByShortID(ctx, uuid.NewString())
+		require.ErrorIs(t, err, db.ErrNotFound)
+		_, err = repo.SelectByLongURL(ctx, uuid.NewString(), "https://yandex.ru/"+uuid.NewString())
+		require.ErrorIs(t, err, db.ErrNotFound)
+	})
+}
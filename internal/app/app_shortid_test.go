@@ -0,0 +1,163 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/shortid"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// listGenerator выдает ID из списка по порядку
+type listGenerator struct {
+	lock sync.Mutex
+	ids  []string
+}
+
+func (g *listGenerator) NewID(context.Context) (string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	id := g.ids[0]
+	g.ids = g.ids[1:]
+	return id, nil
+}
+
+func TestShortIDCollision(t *testing.T) {
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+	require.NoError(t, repo.AddEntity(context.Background(), db.Entity{UserID: "user", ShortID: "taken", LongURL: "https://yandex.ru/"}))
+
+	gen := &listGenerator{ids: []string{"taken", "free1", "taken", "discarded", "free2", "free3", "free4"}}
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout, IDGenerator: gen}
+	ts := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts.Close()
+
+	// занятый ID заменяется следующим
+	resp, body := testRequest(t, ts.URL, http.MethodPost, strings.NewReader("https://yandex.ru/"+uuid.NewString()))
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, *BaseURL+"/free1", body)
+
+	// пакет с занятым ID повторяется целиком с новыми ID
+	resp, out := testBatchRequest(t, ts.URL, batchInput{
+		{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString()},
+		{CorrelationID: "1", OriginalURL: "https://yandex.ru/" + uuid.NewString()},
+	}, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, *BaseURL+"/free2", out[0].ShortURL)
+	require.Equal(t, *BaseURL+"/free3", out[1].ShortURL)
+
+	// занятый выбранный ID - 409 без повторов с новыми ID
+	batch, err := json.Marshal(batchInput{
+		{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString()},
+		{CorrelationID: "1", OriginalURL: "https://yandex.ru/" + uuid.NewString(), Alias: "taken"},
+	})
+	require.NoError(t, err)
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten/batch", http.MethodPost, bytes.NewReader(batch), nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Contains(t, body, `alias "taken" is already taken`)
+	require.Empty(t, gen.ids)
+}
+
+func TestNewIDGenerator(t *testing.T) {
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	// по умолчанию - ID с контрольным символом
+	gen, err := newIDGenerator(cfg.Config{ShortIDLength: 8}, repo)
+	require.NoError(t, err)
+	require.IsType(t, shortid.Checked{}, gen)
+
+	gen, err = newIDGenerator(cfg.Config{ShortIDGenerator: "random", ShortIDLength: 6}, repo)
+	require.NoError(t, err)
+	id, err := gen.NewID(context.Background())
+	require.NoError(t, err)
+	require.Len(t, id, 6)
+
+	// хранилище в памяти не выдает номера - используется счетчик
+	gen, err = newIDGenerator(cfg.Config{ShortIDGenerator: "sequence", ShortIDLength: 8}, repo)
+	require.NoError(t, err)
+	require.IsType(t, &shortid.Obfuscated{}, gen)
+	id, err = gen.NewID(context.Background())
+	require.NoError(t, err)
+	require.Len(t, id, 8)
+
+	_, err = newIDGenerator(cfg.Config{ShortIDGenerator: "snowflake"}, repo)
+	require.Error(t, err)
+}
+
+func TestExpandTypo(t *testing.T) {
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+	gen, err := shortid.NewChecked(8)
+	require.NoError(t, err)
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout, IDGenerator: gen, ShortIDSuggest: true}
+	ts := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts.Close()
+
+	longURL := "https://yandex.ru/" + uuid.NewString()
+	resp, body := testRequest(t, ts.URL, http.MethodPost, strings.NewReader(longURL))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	id := strings.TrimPrefix(body, *BaseURL+"/")
+	require.True(t, shortid.Valid(id))
+
+	// строчные буквы и похожие символы находят запись
+	retyped := strings.NewReplacer("0", "o", "1", "l").Replace(strings.ToLower(id))
+	resp, _ = testRequest(t, ts.URL+"/"+retyped, http.MethodGet, nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	require.Equal(t, longURL, resp.Header.Get("Location"))
+
+	// опечатка: 400 с предложением существующего ID
+	typo := []byte(id)
+	typo[2] = shortid.CheckedAlphabet[(strings.IndexByte(shortid.CheckedAlphabet, typo[2])+1)%len(shortid.CheckedAlphabet)]
+	resp, body = testRequest(t, ts.URL+"/"+string(typo), http.MethodGet, nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var notFound struct {
+		Error      string   `json:"error"`
+		DidYouMean []string `json:"did_you_mean"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &notFound))
+	require.Contains(t, notFound.Error, "typo")
+	require.Contains(t, notFound.DidYouMean, *BaseURL+"/"+id)
+
+	// ID другой длины не ищутся среди соседних: поиск растет с квадратом длины
+	resp, body = testRequest(t, ts.URL+"/"+strings.Repeat("A", 4000), http.MethodGet, nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.NotContains(t, body, "did_you_mean")
+
+	// без предложений - только сообщение об опечатке
+	cfgApp.ShortIDSuggest = false
+	ts2 := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts2.Close()
+	resp, body = testRequest(t, ts2.URL+"/"+string(typo), http.MethodGet, nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, body, "typo")
+
+	// ID с правильным контрольным символом, которого нет
+	unknown, err := gen.NewID(context.Background())
+	require.NoError(t, err)
+	resp, body = testRequest(t, ts.URL+"/"+unknown, http.MethodGet, nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, body, db.ErrNotFound.Error())
+}
@@ -0,0 +1,363 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRepository(t *testing.T) {
+	ctx := context.Background()
+	entity := db.Entity{UserID: "user", ShortID: "short", LongURL: "https://habr.com/ru/all/"}
+
+	// без DatabaseDSN и FileStoragePath - хранилище только в памяти
+	repo, closeRepo, err := newRepository(ctx, cfg.Config{})
+	require.NoError(t, err)
+	require.IsType(t, &repository.Repository{}, repo)
+	require.NoError(t, repo.AddEntity(ctx, entity))
+	closeRepo()
+
+	// с FileStoragePath - хранилище с копией в файле
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+	repo, closeRepo, err = newRepository(ctx, cfg.Config{FileStoragePath: fileName})
+	require.NoError(t, err)
+	require.NoError(t, repo.AddEntity(ctx, entity))
+	closeRepo()
+
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.NotZero(t, info.Size())
+
+	// данные восстанавливаются из файла при повторном запуске
+	repo, closeRepo, err = newRepository(ctx, cfg.Config{FileStoragePath: fileName})
+	require.NoError(t, err)
+	defer closeRepo()
+	restored, err := repo.SelectByShortID(ctx, entity.ShortID)
+	require.NoError(t, err)
+	require.Equal(t, entity, restored)
+}
+
+func TestFileRepositoryRestoreDeleted(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+	entity := db.Entity{UserID: "user", ShortID: "short", LongURL: "https://habr.com/ru/all/"}
+
+	repo, err := repository.New(fileName)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddEntity(ctx, entity))
+	require.NoError(t, repo.SetDeleted(ctx, pool.ToDeleteItem{UserID: entity.UserID, ShortID: entity.ShortID}))
+	repo.Close()
+
+	// после перезапуска запись остается удаленной, а длинный URL - занятым
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	defer repo.Close()
+	restored, err := repo.SelectByShortID(ctx, entity.ShortID)
+	require.NoError(t, err)
+	require.True(t, restored.Deleted)
+	restored, err = repo.SelectByLongURL(ctx, entity.UserID, entity.LongURL)
+	require.NoError(t, err)
+	require.Equal(t, entity.ShortID, restored.ShortID)
+	require.ErrorIs(t, repo.AddEntity(ctx, entity), db.ErrUniqueViolation)
+}
+
+func TestFileRepositoryCompact(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+	repo, err := repository.New(fileName)
+	require.NoError(t, err)
+
+	// удаление порождает записи-обновления, которые удаляются при сжатии
+	entities := make([]db.Entity, 0, 100)
+	for i := 0; i < 100; i++ {
+		e := db.Entity{UserID: "user", ShortID: uuid.NewString(), LongURL: "https://yandex.ru/" + uuid.NewString()}
+		require.NoError(t, repo.AddEntity(ctx, e))
+		require.NoError(t, repo.SetDeleted(ctx, pool.ToDeleteItem{UserID: e.UserID, ShortID: e.ShortID}))
+		e, err = repo.SelectByShortID(ctx, e.ShortID)
+		require.NoError(t, err)
+		require.True(t, e.Deleted)
+		entities = append(entities, e)
+	}
+	require.Equal(t, 200, testCountLines(t, fileName))
+
+	// запись во время сжатия не блокируется и не теряется
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			e := db.Entity{UserID: "user", ShortID: uuid.NewString(), LongURL: "https://habr.com/" + uuid.NewString()}
+			assert.NoError(t, repo.AddEntity(ctx, e))
+			entities = append(entities, e)
+		}
+	}()
+	require.NoError(t, repo.Compact())
+	wg.Wait()
+	repo.Close()
+
+	require.Equal(t, len(entities), testCountLines(t, fileName))
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	defer repo.Close()
+	selection, err := repo.SelectByUser(ctx, "user")
+	require.NoError(t, err)
+	require.ElementsMatch(t, entities, selection)
+}
+
+func testCountLines(t *testing.T, fileName string) int {
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestFileRepositoryRecovery(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+
+	// файл старого формата: строки JSON без длины и контрольной суммы
+	legacy := db.Entity{UserID: "user", ShortID: "legacy", LongURL: "https://habr.com/ru/all/"}
+	data, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fileName, append(data, '\n'), 0644))
+
+	repo, err := repository.NewWithOptions(fileName, repository.Options{SyncPolicy: repository.SyncAlways})
+	require.NoError(t, err)
+	entities := []db.Entity{legacy}
+	for i := 0; i < 3; i++ {
+		e := db.Entity{UserID: "user", ShortID: uuid.NewString(), LongURL: "https://yandex.ru/" + uuid.NewString()}
+		require.NoError(t, repo.AddEntity(ctx, e))
+		entities = append(entities, e)
+	}
+	repo.Close()
+
+	// порча записи в середине файла и оборванная запись в конце
+	data, err = os.ReadFile(fileName)
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	lines[2] = bytes.Replace(lines[2], []byte("yandex"), []byte("yandeX"), 1)
+	data = bytes.Join(lines, nil)
+	data = append(data, lines[3][:len(lines[3])/2]...)
+	require.NoError(t, os.WriteFile(fileName, data, 0644))
+
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	selection, err := repo.SelectByUser(ctx, "user")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []db.Entity{entities[0], entities[1], entities[3]}, selection)
+
+	// хвост обрезан, новые записи дописываются после последней целой записи
+	e := db.Entity{UserID: "user", ShortID: uuid.NewString(), LongURL: "https://yandex.ru/" + uuid.NewString()}
+	require.NoError(t, repo.AddEntity(ctx, e))
+	repo.Close()
+
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	defer repo.Close()
+	got, err := repo.SelectByShortID(ctx, e.ShortID)
+	require.NoError(t, err)
+	require.Equal(t, e, got)
+}
+
+func TestFileRepositoryTornBatch(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+
+	repo, err := repository.NewWithOptions(fileName, repository.Options{SyncPolicy: repository.SyncAlways})
+	require.NoError(t, err)
+	single := db.Entity{UserID: "user", ShortID: "single", LongURL: "https://habr.com/ru/all/"}
+	require.NoError(t, repo.AddEntity(ctx, single))
+	batch := db.BatchInput{
+		{CorrelationID: "0", OriginalURL: "https://yandex.ru/" + uuid.NewString(), ShortID: uuid.NewString()},
+		{CorrelationID: "1", OriginalURL: "https://yandex.ru/" + uuid.NewString(), ShortID: uuid.NewString()},
+		{CorrelationID: "2", OriginalURL: "https://yandex.ru/" + uuid.NewString(), ShortID: uuid.NewString()},
+	}
+	require.NoError(t, repo.AddEntityBatch(ctx, "user", batch))
+	repo.Close()
+
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	require.Len(t, lines, 5) // одна запись, пакет из трех записей и пустой остаток
+
+	// пакет оборван после целых записей и посреди записи: не применяется ни одна запись пакета
+	for _, torn := range [][]byte{
+		bytes.Join(lines[:3], nil),
+		append(bytes.Join(lines[:3], nil), lines[3][:len(lines[3])/2]...),
+	} {
+		require.NoError(t, os.WriteFile(fileName, torn, 0644))
+		repo, err = repository.New(fileName)
+		require.NoError(t, err)
+		selection, err := repo.SelectByUser(ctx, "user")
+		require.NoError(t, err)
+		require.Equal(t, []db.Entity{single}, selection)
+
+		// неполный пакет обрезан, новые записи читаются после перезапуска
+		e := db.Entity{UserID: "user", ShortID: uuid.NewString(), LongURL: "https://yandex.ru/" + uuid.NewString()}
+		require.NoError(t, repo.AddEntity(ctx, e))
+		repo.Close()
+		repo, err = repository.New(fileName)
+		require.NoError(t, err)
+		selection, err = repo.SelectByUser(ctx, "user")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []db.Entity{single, e}, selection)
+		repo.Close()
+	}
+}
+
+func TestFileRepositoryDeleteQueue(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+	entity := db.Entity{UserID: "user", ShortID: "short", LongURL: "https://habr.com/ru/all/"}
+
+	// элементы приняты, но сервис остановлен до их обработки
+	repo, err := repository.New(fileName)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddEntity(ctx, entity))
+	require.NoError(t, repo.EnqueueDeletes(ctx, []pool.ToDeleteItem{{UserID: entity.UserID, ShortID: entity.ShortID}}))
+	repo.Close()
+
+	// после перезапуска пул удаляет неподтвержденные элементы и подтверждает их
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	defer repo.Close()
+	poolCtx, cancel := context.WithCancel(ctx)
+	deleterPool := pool.NewWithOptions(poolCtx, repo, pool.Options{FlushInterval: 10 * time.Millisecond})
+	require.Eventually(t, func() bool {
+		got, err := repo.SelectByShortID(ctx, entity.ShortID)
+		return err == nil && got.Deleted
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(fileName + ".deletes")
+		return err == nil && info.Size() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	deleterPool.Close()
+
+	pending, err := repo.PendingDeletes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestFileRepositoryPurge(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+	deleted := db.Entity{UserID: "user", ShortID: "deleted", LongURL: "https://habr.com/ru/all/"}
+	kept := db.Entity{UserID: "user", ShortID: "kept", LongURL: "https://yandex.ru/"}
+
+	repo, err := repository.New(fileName)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddEntity(ctx, deleted))
+	require.NoError(t, repo.AddEntity(ctx, kept))
+	require.NoError(t, repo.SetDeletedBatch(ctx, deleted.UserID, []string{deleted.ShortID}))
+	n, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	repo.Close()
+
+	// окончательное удаление сохраняется в файле, сжатие убирает удаленную запись из файла
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	defer repo.Close()
+	_, err = repo.SelectByShortID(ctx, deleted.ShortID)
+	require.ErrorIs(t, err, db.ErrNotFound)
+	require.NoError(t, repo.Compact())
+	require.Equal(t, 1, testCountLines(t, fileName))
+}
+
+func TestFileRepositoryLegacyDeleted(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "storage.txt")
+	restored := db.Entity{Deleted: true, UserID: "user", ShortID: "restored", LongURL: "https://habr.com/ru/all/"}
+	purged := db.Entity{Deleted: true, UserID: "user", ShortID: "purged", LongURL: "https://yandex.ru/"}
+
+	// записи удалены до появления времени удаления в файле
+	repo, err := repository.New(fileName)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddEntity(ctx, restored))
+	require.NoError(t, repo.AddEntity(ctx, purged))
+	repo.Close()
+
+	// при загрузке временем удаления становится время загрузки, и оно сохраняется в файле
+	loadedAt := time.Now()
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	repo.Close()
+	repo, err = repository.New(fileName)
+	require.NoError(t, err)
+	defer repo.Close()
+	e, err := repo.SelectByShortID(ctx, purged.ShortID)
+	require.NoError(t, err)
+	require.NotNil(t, e.DeletedAt)
+	require.WithinDuration(t, loadedAt, *e.DeletedAt, time.Minute)
+
+	// одно правило для восстановления и окончательного удаления: в пределах срока запись
+	// восстанавливается и не удаляется окончательно, после срока - наоборот
+	ids, err := repo.RestoreBatch(ctx, restored.UserID, []string{restored.ShortID}, loadedAt.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []string{restored.ShortID}, ids)
+	n, err := repo.PurgeDeleted(ctx, loadedAt.Add(-time.Hour), 10)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	ids, err = repo.RestoreBatch(ctx, purged.UserID, []string{purged.ShortID}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	n, err = repo.PurgeDeleted(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
+
+func TestFileSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "storage.txt")
+	entities := []db.Entity{
+		{UserID: "user", ShortID: "1", LongURL: "https://habr.com/"},
+		{UserID: "user", ShortID: "2", LongURL: "https://yandex.ru/"},
+		{UserID: "user", ShortID: "3", LongURL: "https://golang.org/"},
+	}
+
+	repo, err := repository.New(fileName)
+	require.NoError(t, err)
+	for _, e := range entities {
+		require.NoError(t, repo.AddEntity(ctx, e))
+	}
+	require.NoError(t, repo.SetDeletedBatch(ctx, "user", []string{"1", "2"}))
+	_, err = repo.RestoreBatch(ctx, "user", []string{"1"}, time.Time{})
+	require.NoError(t, err)
+	n, err := repo.PurgeDeleted(ctx, time.Now().Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	repo.Close()
+
+	// текущее состояние в порядке добавления
+	snapshot, stats, err := repository.ReadSnapshot(fileName)
+	require.NoError(t, err)
+	require.Equal(t, repository.SnapshotStats{Records: 7}, stats)
+	require.Equal(t, []db.Entity{entities[0], entities[2]}, snapshot)
+
+	// выгруженные записи читаются хранилищем
+	exported := filepath.Join(dir, "exported.txt")
+	file, err := os.Create(exported)
+	require.NoError(t, err)
+	require.NoError(t, repository.WriteEntities(file, snapshot))
+	require.NoError(t, file.Close())
+	repo, err = repository.New(exported)
+	require.NoError(t, err)
+	defer repo.Close()
+	selection, err := repo.SelectByUser(ctx, "user")
+	require.NoError(t, err)
+	require.ElementsMatch(t, snapshot, selection)
+}
@@ -71,7 +71,7 @@ func TestGZipJSONAPI(t *testing.T) {
 }
 
 func TestJSONAPI(t *testing.T) {
-	//_ = os.Remove(cfgApp.FileStoragePath)
+	_ = os.Remove(*FileStoragePath)
 	cfgApp := cfg.Config{
 		ServerAddress:   *ServerAddress,
 		BaseURL:         *BaseURL,
@@ -139,7 +139,7 @@ func TestJSONAPI(t *testing.T) {
 }
 
 func TestTextAPI(t *testing.T) {
-	//_ = os.Remove(cfgApp.FileStoragePath)
+	_ = os.Remove(*FileStoragePath)
 	cfgApp := cfg.Config{
 		ServerAddress:   *ServerAddress,
 		BaseURL:         *BaseURL,
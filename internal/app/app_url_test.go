@@ -0,0 +1,109 @@
+package app
+
+import (
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/policy"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestURLCanonicalization(t *testing.T) {
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout, URLStripTracking: true}
+	ts := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts.Close()
+
+	// варианты записи одного URL - дубликаты
+	path := uuid.NewString()
+	resp, shortURL := testGZipRequestCookie(t, ts.URL, http.MethodPost, strings.NewReader("HTTPS://Habr.COM:443/"+path+"?utm_source=tg"), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cookies := resp.Cookies()
+
+	resp, dup := testGZipRequestCookie(t, ts.URL, http.MethodPost, strings.NewReader("https://habr.com/"+path), cookies)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.Equal(t, shortURL, dup)
+
+	resp, _ = testRequest(t, ts.URL+strings.TrimPrefix(shortURL, *BaseURL), http.MethodGet, nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "https://habr.com/"+path, resp.Header.Get("Location"))
+
+	// не URL - 400 с причиной
+	resp, body := testGZipRequestCookie(t, ts.URL, http.MethodPost, strings.NewReader("habr.com"), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, body, "http or https")
+
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten", http.MethodPost, strings.NewReader(`{"url":"ftp://habr.com/"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, body, "http or https")
+
+	batch := `[{"correlation_id":"0","original_url":"https://habr.com/"},{"correlation_id":"1","original_url":"https://"}]`
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten/batch", http.MethodPost, strings.NewReader(batch), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, body, "correlation_id 1")
+	require.Contains(t, body, "no host")
+}
+
+func TestPolicy(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"block_domains": ["phishing.example.com", "пример.рф"], "block_cidrs": ["10.0.0.0/8", "127.0.0.0/8"]}`), 0666))
+	urlPolicy, err := policy.New(fileName, 0)
+	require.NoError(t, err)
+	defer urlPolicy.Close()
+
+	repo, err := repository.New("")
+	require.NoError(t, err)
+	defer repo.Close()
+	cfgApp := cfg.Config{BaseURL: *BaseURL, CtxTimeout: *CtxTimeout, Policy: urlPolicy}
+	ts := httptest.NewServer(handlers.NewRouter(repo, cfgApp))
+	defer ts.Close()
+
+	resp, _ := testGZipRequestCookie(t, ts.URL, http.MethodPost, strings.NewReader("https://habr.com/"+uuid.NewString()), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// запрещенный URL - 403 с причиной
+	resp, body := testGZipRequestCookie(t, ts.URL, http.MethodPost, strings.NewReader("https://login.phishing.example.com/"), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Contains(t, body, "domain phishing.example.com is blocked")
+
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten", http.MethodPost, strings.NewReader(`{"url":"http://10.0.0.1/admin"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Contains(t, body, "10.0.0.0/8")
+
+	// национальный домен в правилах совпадает с хостом URL в punycode
+	resp, body = testGZipRequestCookie(t, ts.URL, http.MethodPost, strings.NewReader("http://пример.рф/"), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Contains(t, body, "domain xn--e1afmkfd.xn--p1ai is blocked")
+
+	// адрес в любой записи, которую принимает браузер
+	for _, longURL := range []string{"http://2130706433/", "http://0177.0.0.1/", "http://127.1/", "http://0x7f.1/"} {
+		resp, body = testGZipRequestCookie(t, ts.URL, http.MethodPost, strings.NewReader(longURL), nil)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusForbidden, resp.StatusCode, longURL)
+		require.Contains(t, body, "127.0.0.0/8", longURL)
+	}
+
+	batch := `[{"correlation_id":"0","original_url":"https://habr.com/"},{"correlation_id":"1","original_url":"https://phishing.example.com/"}]`
+	resp, body = testGZipRequestCookie(t, ts.URL+"/api/shorten/batch", http.MethodPost, strings.NewReader(batch), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Contains(t, body, "correlation_id 1")
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/dualwrite"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/shortid"
+	"log"
+	"time"
+)
+
+// newRepository выбор хранилища по конфигурации: Postgres, если задан DatabaseDSN,
+// файл - если задан FileStoragePath, иначе только оперативная память.
+// В режиме DualWrite используются оба хранилища. Вместе с хранилищем возвращается функция его закрытия
+func newRepository(ctx context.Context, cfgApp cfg.Config) (handlers.Repositorier, func(), error) {
+	switch {
+	case cfgApp.DualWrite != "":
+		return newDualWriteRepository(ctx, cfgApp)
+
+	case cfgApp.DatabaseDSN != "":
+		dbPool, closeDB, err := newPostgresRepository(ctx, cfgApp)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Println("storage: postgres")
+		return dbPool, closeDB, nil
+
+	case cfgApp.FileStoragePath != "":
+		fileRepo, err := newFileRepository(cfgApp)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("storage: file %s\n", cfgApp.FileStoragePath)
+		return fileRepo, fileRepo.Close, nil
+
+	default:
+		memRepo, err := repository.New("")
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Println("storage: in-memory")
+		return memRepo, memRepo.Close, nil
+	}
+}
+
+func newPostgresRepository(ctx context.Context, cfgApp cfg.Config) (*db.T, func(), error) {
+	dbPool, err := db.New(ctx, cfgApp.DatabaseDSN)
+	if err != nil {
+		if dbPool.Pool != nil {
+			dbPool.Close()
+		}
+		return nil, nil, err
+	}
+	return &dbPool, dbPool.Close, nil
+}
+
+func newFileRepository(cfgApp cfg.Config) (*repository.Repository, error) {
+	return repository.NewWithOptions(cfgApp.FileStoragePath, repository.Options{
+		CompactInterval: cfgApp.FileCompactInterval,
+		SyncPolicy:      repository.SyncPolicy(cfgApp.FileSyncPolicy),
+		SyncInterval:    cfgApp.FileSyncInterval,
+	})
+}
+
+// newDualWriteRepository запись в Postgres и файл, чтение из основного хранилища cfgApp.DualWrite
+func newDualWriteRepository(ctx context.Context, cfgApp cfg.Config) (handlers.Repositorier, func(), error) {
+	if cfgApp.DatabaseDSN == "" || cfgApp.FileStoragePath == "" {
+		return nil, nil, fmt.Errorf("dual write requires both postgres url and storage file")
+	}
+	if cfgApp.DualWrite != "postgres" && cfgApp.DualWrite != "file" {
+		return nil, nil, fmt.Errorf("unknown dual write primary %q: use postgres or file", cfgApp.DualWrite)
+	}
+
+	dbPool, closeDB, err := newPostgresRepository(ctx, cfgApp)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileRepo, err := newFileRepository(cfgApp)
+	if err != nil {
+		closeDB()
+		return nil, nil, err
+	}
+	closeBoth := func() {
+		fileRepo.Close()
+		closeDB()
+	}
+
+	opts := dualwrite.Options{Fallback: cfgApp.DualWriteFallback, CompareReads: cfgApp.DualWriteCompareReads}
+	if cfgApp.DualWrite == "postgres" {
+		log.Printf("storage: dual write, primary postgres, secondary file %s\n", cfgApp.FileStoragePath)
+		return dualwrite.New(dbPool, fileRepo, opts), closeBoth, nil
+	}
+	log.Printf("storage: dual write, primary file %s, secondary postgres\n", cfgApp.FileStoragePath)
+	return dualwrite.New(fileRepo, dbPool, opts), closeBoth, nil
+}
+
+// newIDGenerator генератор коротких ID по конфигурации. Номера для sequence выдает хранилище
+// (последовательность Postgres), а без нее - счетчик, начатый с текущего времени в миллисекундах,
+// чтобы номера не повторялись после перезапуска
+func newIDGenerator(cfgApp cfg.Config, repo handlers.Repositorier) (shortid.IDGenerator, error) {
+	switch cfgApp.ShortIDGenerator {
+	case "", "checked":
+		return shortid.NewChecked(cfgApp.ShortIDLength)
+	case "random":
+		return shortid.NewRandom(cfgApp.ShortIDLength)
+	case "sequence":
+		seq, ok := repo.(shortid.Sequence)
+		if !ok {
+			seq = shortid.NewCounter(uint64(time.Now().UnixMilli()))
+		}
+		return shortid.NewObfuscated(seq, cfgApp.ShortIDLength, cfgApp.ShortIDSalt)
+	case "uuid":
+		return shortid.UUID{}, nil
+	default:
+		return nil, fmt.Errorf("unknown short ID generator %q: use checked, random, sequence or uuid", cfgApp.ShortIDGenerator)
+	}
+}
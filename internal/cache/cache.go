@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/purge"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// T кэш записей по короткому ID поверх хранилища для перехода по короткой ссылке.
+// Хранится не более Capacity записей, давно не запрошенные вытесняются. Отсутствие записи
+// тоже кэшируется, на время NegativeTTL. Изменения записей идут в хранилище и сбрасывают кэш
+type T struct {
+	handlers.Repositorier
+	opts Options
+
+	lock    sync.Mutex
+	items   map[string]*list.Element
+	order   *list.List // начало - последняя запрошенная запись
+	version uint64     // номер сброса: запись, прочитанная до сброса, в кэш не попадает
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// Options размер и время жизни кэша
+type Options struct {
+	Capacity    int           // записей в кэше (по умолчанию 10000)
+	TTL         time.Duration // время жизни найденной записи (по умолчанию 1 минута)
+	NegativeTTL time.Duration // время жизни отметки об отсутствии записи (по умолчанию 10 секунд)
+}
+
+// Stats счетчики обращений к кэшу
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64 // записей, вытесненных по размеру
+	Size      int
+}
+
+type entry struct {
+	shortID string
+	entity  db.Entity
+	found   bool
+	expires time.Time
+}
+
+func New(repo handlers.Repositorier, opts Options) *T {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 10000
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = 10 * time.Second
+	}
+	return &T{
+		Repositorier: repo,
+		opts:         opts,
+		items:        make(map[string]*list.Element, opts.Capacity),
+		order:        list.New(),
+	}
+}
+
+func (c *T) Stats() Stats {
+	c.lock.Lock()
+	size := c.order.Len()
+	c.lock.Unlock()
+	return Stats{
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Evictions: atomic.LoadInt64(&c.evictions),
+		Size:      size,
+	}
+}
+
+// SelectByShortID запись из кэша; при промахе - из хранилища с сохранением в кэш
+func (c *T) SelectByShortID(ctx context.Context, shortID string) (db.Entity, error) {
+	if e, ok := c.get(shortID); ok {
+		atomic.AddInt64(&c.hits, 1)
+		if !e.found {
+			return db.Entity{}, db.ErrNotFound
+		}
+		return e.entity, nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	c.lock.Lock()
+	version := c.version
+	c.lock.Unlock()
+
+	entity, err := c.Repositorier.SelectByShortID(ctx, shortID)
+	switch {
+	case err == nil:
+		c.put(version, entry{shortID: shortID, entity: entity, found: true, expires: time.Now().Add(c.opts.TTL)})
+	case errors.Is(err, db.ErrNotFound):
+		c.put(version, entry{shortID: shortID, expires: time.Now().Add(c.opts.NegativeTTL)})
+	}
+	return entity, err
+}
+
+// SelectByShortIDs чтение из хранилища без кэша: проверяемые ID в основном не существуют,
+// и их отрицательные записи вытеснили бы из кэша полезные
+func (c *T) SelectByShortIDs(ctx context.Context, shortIDs []string) ([]db.Entity, error) {
+	return c.Repositorier.SelectByShortIDs(ctx, shortIDs)
+}
+
+func (c *T) get(shortID string) (entry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.items[shortID]
+	if !ok {
+		return entry{}, false
+	}
+	e := el.Value.(entry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, shortID)
+		return entry{}, false
+	}
+	c.order.MoveToFront(el)
+	return e, true
+}
+
+// put сохранение записи, если кэш не сбрасывался после ее чтения из хранилища
+func (c *T) put(version uint64, e entry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if version != c.version {
+		return
+	}
+	if el, ok := c.items[e.shortID]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[e.shortID] = c.order.PushFront(e)
+	for c.order.Len() > c.opts.Capacity {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.items, el.Value.(entry).shortID)
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+// Invalidate удаление записей из кэша
+func (c *T) Invalidate(shortIDs ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.version++
+	for _, id := range shortIDs {
+		if el, ok := c.items[id]; ok {
+			c.order.Remove(el)
+			delete(c.items, id)
+		}
+	}
+}
+
+// Clear удаление всех записей из кэша
+func (c *T) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.version++
+	c.items = make(map[string]*list.Element, c.opts.Capacity)
+	c.order.Init()
+}
+
+// AddEntity сброс отметки об отсутствии записи с тем же ID
+func (c *T) AddEntity(ctx context.Context, entity db.Entity) error {
+	err := c.Repositorier.AddEntity(ctx, entity)
+	c.Invalidate(entity.ShortID)
+	return err
+}
+
+func (c *T) AddEntityBatch(ctx context.Context, userID string, input db.BatchInput) error {
+	err := c.Repositorier.AddEntityBatch(ctx, userID, input)
+	ids := make([]string, 0, len(input))
+	for _, item := range input {
+		if item.ShortID != "" {
+			ids = append(ids, item.ShortID)
+		}
+	}
+	c.Invalidate(ids...)
+	return err
+}
+
+func (c *T) SetDeletedBatch(ctx context.Context, userID string, shortIDs []string) error {
+	err := c.Repositorier.SetDeletedBatch(ctx, userID, shortIDs)
+	c.Invalidate(shortIDs...)
+	return err
+}
+
+func (c *T) SetDeleted(ctx context.Context, item pool.ToDeleteItem) error {
+	err := c.Repositorier.SetDeleted(ctx, item)
+	c.Invalidate(item.ShortID)
+	return err
+}
+
+func (c *T) RestoreBatch(ctx context.Context, userID string, shortIDs []string, deletedSince time.Time) ([]string, error) {
+	restored, err := c.Repositorier.RestoreBatch(ctx, userID, shortIDs, deletedSince)
+	c.Invalidate(shortIDs...)
+	return restored, err
+}
+
+// PurgeDeleted окончательное удаление в хранилище. Удаленные ID неизвестны, поэтому кэш сбрасывается целиком
+func (c *T) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	purger, ok := c.Repositorier.(purge.Purger)
+	if !ok {
+		return 0, nil
+	}
+	n, err := purger.PurgeDeleted(ctx, deletedBefore, limit)
+	if n > 0 {
+		c.Clear()
+	}
+	return n, err
+}
+
+// Постоянная очередь удаления ведется хранилищем
+
+func (c *T) EnqueueDeletes(ctx context.Context, items []pool.ToDeleteItem) error {
+	if journal, ok := c.Repositorier.(pool.Journal); ok {
+		return journal.EnqueueDeletes(ctx, items)
+	}
+	return nil
+}
+
+func (c *T) PendingDeletes(ctx context.Context) ([]pool.ToDeleteItem, error) {
+	if journal, ok := c.Repositorier.(pool.Journal); ok {
+		return journal.PendingDeletes(ctx)
+	}
+	return nil, nil
+}
+
+func (c *T) AckDeletes(ctx context.Context, userID string, shortIDs []string) error {
+	if journal, ok := c.Repositorier.(pool.Journal); ok {
+		return journal.AckDeletes(ctx, userID, shortIDs)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"testing"
+	"time"
+)
+
+// countingRepo хранилище со счетчиком чтений по короткому ID
+type countingRepo struct {
+	*repository.Repository
+	selects int
+}
+
+func (r *countingRepo) SelectByShortID(ctx context.Context, shortID string) (db.Entity, error) {
+	r.selects++
+	return r.Repository.SelectByShortID(ctx, shortID)
+}
+
+func newCountingRepo(t *testing.T) *countingRepo {
+	repo, err := repository.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(repo.Close)
+	return &countingRepo{Repository: repo}
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	repo := newCountingRepo(t)
+	c := New(repo, Options{Capacity: 2, TTL: time.Hour, NegativeTTL: time.Hour})
+
+	e := db.Entity{UserID: "user", ShortID: "a", LongURL: "https://yandex.ru/a"}
+	if err := c.AddEntity(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if got, err := c.SelectByShortID(ctx, "a"); err != nil || got != e {
+			t.Fatalf("got %+v, %v", got, err)
+		}
+	}
+	if repo.selects != 1 {
+		t.Fatalf("expected one read from storage, got %d", repo.selects)
+	}
+
+	// отсутствие записи кэшируется и сбрасывается при добавлении записи с этим ID
+	for i := 0; i < 2; i++ {
+		if _, err := c.SelectByShortID(ctx, "b"); !errors.Is(err, db.ErrNotFound) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	}
+	if repo.selects != 2 {
+		t.Fatalf("expected negative hit, got %d reads", repo.selects)
+	}
+	if err := c.AddEntity(ctx, db.Entity{UserID: "user", ShortID: "b", LongURL: "https://yandex.ru/b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SelectByShortID(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// удаление сбрасывает запись
+	if err := c.SetDeletedBatch(ctx, "user", []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.SelectByShortID(ctx, "a"); err != nil || !got.Deleted {
+		t.Fatalf("expected deleted entity, got %+v, %v", got, err)
+	}
+
+	// вытеснение давно не запрошенной записи: в кэше a и b, c вытесняет b
+	if _, err := c.SelectByShortID(ctx, "c"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	stats := c.Stats()
+	if stats.Size != 2 || stats.Evictions != 1 || stats.Hits != 3 || stats.Misses != 5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	c.Clear()
+	if c.Stats().Size != 0 {
+		t.Fatal("cache is not empty after clear")
+	}
+}
+
+func TestCacheSelectByShortIDs(t *testing.T) {
+	ctx := context.Background()
+	repo := newCountingRepo(t)
+	c := New(repo, Options{Capacity: 10, TTL: time.Hour, NegativeTTL: time.Hour})
+	if err := c.AddEntity(ctx, db.Entity{UserID: "user", ShortID: "a", LongURL: "https://yandex.ru/a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// проверка набора ID не создает записей в кэше, в том числе отрицательных
+	selection, err := c.SelectByShortIDs(ctx, []string{"a", "b", "c"})
+	if err != nil || len(selection) != 1 || selection[0].ShortID != "a" {
+		t.Fatalf("unexpected selection %v, %v", selection, err)
+	}
+	if stats := c.Stats(); stats.Size != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	repo := newCountingRepo(t)
+	c := New(repo, Options{Capacity: 10, TTL: 10 * time.Millisecond, NegativeTTL: 10 * time.Millisecond})
+
+	if _, err := c.SelectByShortID(ctx, "a"); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	// запись добавлена в обход кэша: видна после истечения отметки об отсутствии
+	if err := repo.AddEntity(ctx, db.Entity{UserID: "user", ShortID: "a", LongURL: "https://yandex.ru/a"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := c.SelectByShortID(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if repo.selects != 2 {
+		t.Fatalf("expected two reads from storage, got %d", repo.selects)
+	}
+}
@@ -3,9 +3,12 @@ package cfg
 import (
 	"flag"
 	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/policy"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/shortid"
 	"github.com/caarlos0/env/v6"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -14,7 +17,65 @@ type Config struct {
 	FileStoragePath string `env:"FILE_STORAGE_PATH" envDefault:"./storage.txt"`
 	DatabaseDSN     string `env:"DATABASE_DSN"`
 	CtxTimeout      int64  `env:"CTX_TIMEOUT" envDefault:"500"`
-	DeleterChan     chan pool.ToDeleteItem
+	DeleterPool     *pool.DeleterPoolT
+	IDGenerator     shortid.IDGenerator // по умолчанию shortid.UUID
+	Policy          *policy.T           // nil - длинные URL не проверяются
+
+	// генератор коротких ID: checked - случайные символы без похожих друг на друга и контрольный символ,
+	// random - случайные символы, sequence - номер последовательности Postgres, переставленный
+	// с солью ShortIDSalt, uuid - UUID. ShortIDLength - длина ID (для sequence - минимальная)
+	ShortIDGenerator string `env:"SHORT_ID_GENERATOR" envDefault:"checked"`
+	ShortIDLength    int    `env:"SHORT_ID_LENGTH" envDefault:"8"`
+	ShortIDSalt      string `env:"SHORT_ID_SALT"`
+	// предлагать существующие ID, похожие на ненайденный; выключено по умолчанию,
+	// так как ответ раскрывает чужие короткие ID
+	ShortIDSuggest bool `env:"SHORT_ID_SUGGEST" envDefault:"false"`
+
+	// длинный URL: максимальная длина и удаление параметров отслеживания (utm_*, fbclid и подобных)
+	URLMaxLength     int  `env:"URL_MAX_LENGTH" envDefault:"1024"`
+	URLStripTracking bool `env:"URL_STRIP_TRACKING" envDefault:"false"`
+
+	// файл правил допустимых длинных URL (пусто - без проверки) и период проверки его изменений
+	PolicyFile           string        `env:"POLICY_FILE"`
+	PolicyReloadInterval time.Duration `env:"POLICY_RELOAD_INTERVAL" envDefault:"10s"`
+
+	// переезд между хранилищами: запись в Postgres и файл одновременно, чтение из основного.
+	// DualWrite - основное хранилище: postgres или file; пустое значение - режим выключен
+	DualWrite             string `env:"DUAL_WRITE"`
+	DualWriteFallback     bool   `env:"DUAL_WRITE_FALLBACK" envDefault:"true"`
+	DualWriteCompareReads bool   `env:"DUAL_WRITE_COMPARE_READS" envDefault:"false"`
+
+	// период сжатия файла хранилища, 0 - без сжатия
+	FileCompactInterval time.Duration `env:"FILE_COMPACT_INTERVAL" envDefault:"10m"`
+	// сброс файла хранилища на диск: always, interval или never
+	FileSyncPolicy   string        `env:"FILE_SYNC_POLICY" envDefault:"interval"`
+	FileSyncInterval time.Duration `env:"FILE_SYNC_INTERVAL" envDefault:"1s"`
+
+	// пул удаления: удаление пакетами ID пользователя по размеру пакета или по таймеру
+	DeleteWorkers       int           `env:"DELETE_WORKERS" envDefault:"4"`
+	DeleteBufferSize    int           `env:"DELETE_BUFFER_SIZE" envDefault:"1000"`
+	DeleteBatchSize     int           `env:"DELETE_BATCH_SIZE" envDefault:"100"`
+	DeleteFlushInterval time.Duration `env:"DELETE_FLUSH_INTERVAL" envDefault:"1s"`
+	// повтор удаления при временных ошибках хранилища с экспоненциальной задержкой
+	DeleteMaxAttempts   int           `env:"DELETE_MAX_ATTEMPTS" envDefault:"5"`
+	DeleteRetryDelay    time.Duration `env:"DELETE_RETRY_DELAY" envDefault:"100ms"`
+	DeleteRetryMaxDelay time.Duration `env:"DELETE_RETRY_MAX_DELAY" envDefault:"10s"`
+	// время на удаление оставшихся элементов при остановке сервиса
+	DeleteDrainTimeout time.Duration `env:"DELETE_DRAIN_TIMEOUT" envDefault:"10s"`
+	// срок, в течение которого удаленную запись можно восстановить; после него запись может быть
+	// удалена окончательно. 0 - без ограничения
+	DeleteGracePeriod time.Duration `env:"DELETE_GRACE_PERIOD" envDefault:"720h"`
+
+	// окончательное удаление записей с истекшим DeleteGracePeriod: период запуска (0 - не удалять)
+	// и размер пакета
+	PurgeInterval  time.Duration `env:"PURGE_INTERVAL" envDefault:"1h"`
+	PurgeBatchSize int           `env:"PURGE_BATCH_SIZE" envDefault:"1000"`
+
+	// кэш перехода по короткой ссылке: записей в кэше (0 - без кэша), время жизни найденной записи
+	// и отметки об отсутствии записи
+	CacheSize        int           `env:"CACHE_SIZE" envDefault:"10000"`
+	CacheTTL         time.Duration `env:"CACHE_TTL" envDefault:"1m"`
+	CacheNegativeTTL time.Duration `env:"CACHE_NEGATIVE_TTL" envDefault:"10s"`
 }
 
 func New() (Config, error) {
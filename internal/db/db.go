@@ -7,8 +7,10 @@ import (
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	_ "github.com/lib/pq"
+	"time"
 )
 
 type T struct {
@@ -16,38 +18,50 @@ type T struct {
 }
 
 type Entity struct {
-	Deleted bool   `json:"deleted"`
-	UserID  string `json:"user_id"`
-	ShortID string `json:"id"`
-	LongURL string `json:"url"`
+	Deleted   bool       `json:"deleted"`
+	UserID    string     `json:"user_id"`
+	ShortID   string     `json:"id"`
+	LongURL   string     `json:"url"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // время удаления; nil для неудаленных записей
+}
+
+// entityColumns столбцы urls в порядке полей Entity для scanEntity
+const entityColumns = "deleted, user_id, short_id, long_url, deleted_at"
+
+func scanEntity(row pgx.Row) (Entity, error) {
+	var e Entity
+	err := row.Scan(&e.Deleted, &e.UserID, &e.ShortID, &e.LongURL, &e.DeletedAt)
+	return e, err
 }
 
 type BatchInput []BatchInputItem
 type BatchInputItem struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	Alias         string `json:"alias,omitempty"` // короткий ID, выбранный пользователем
 	ShortID       string `json:"-"`
 	Deleted       bool   `json:"-"`
+	Status        string `json:"-"` // результат AddEntityBatch: BatchCreated или BatchExists
 }
 
+// Результат добавления элемента пакета. Для BatchExists в ShortID записывается ID существующей записи
+const (
+	BatchCreated = "created"
+	BatchExists  = "exists"
+)
+
 var ErrUniqueViolation = errors.New("long URL already exist")
+var ErrShortIDTaken = errors.New("short ID already exist")
+var ErrNotFound = errors.New("a non-existent ID was requested")
 
+// New подключение к БД с применением неприменённых миграций
 func New(ctx context.Context, url string) (T, error) {
-	var pool T
-	var err error
-	pool.Pool, err = pgxpool.Connect(ctx, url)
+	pool, err := Connect(ctx, url)
 	if err != nil {
 		return pool, err
 	}
 
-	// создание таблицы
-	sql1 := "create table if not exists urls (" +
-		"id serial primary key, " +
-		"deleted boolean not null," +
-		"user_id varchar(512) not null, " +
-		"short_id varchar(512) not null unique, " +
-		"long_url varchar(1024) not null unique)"
-	_, err = pool.Exec(ctx, sql1)
+	_, err = pool.MigrateUp(ctx, 0)
 	if err != nil {
 		return pool, err
 	}
@@ -55,77 +69,100 @@ func New(ctx context.Context, url string) (T, error) {
 	return pool, nil
 }
 
+// Connect подключение к БД без применения миграций
+func Connect(ctx context.Context, url string) (T, error) {
+	var pool T
+	var err error
+	pool.Pool, err = pgxpool.Connect(ctx, url)
+	return pool, err
+}
+
 func (d *T) AddEntity(ctx context.Context, e Entity) error {
-	sql := "insert into urls values (default, $1, $2, $3, $4)"
-	_, err := d.Pool.Exec(ctx, sql, e.Deleted, e.UserID, e.ShortID, e.LongURL)
+	sql := "insert into urls (" + entityColumns + ") values ($1, $2, $3, $4, $5)"
+	_, err := d.Pool.Exec(ctx, sql, e.Deleted, e.UserID, e.ShortID, e.LongURL, e.DeletedAt)
+	return uniqueViolation(err)
+}
 
+// uniqueViolation замена ошибки нарушения уникальности на ErrShortIDTaken для короткого ID
+// и на ErrUniqueViolation для длинного URL
+func uniqueViolation(err error) error {
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) {
 		if pgErr.Code == pgerrcode.UniqueViolation {
+			if pgErr.ConstraintName == shortIDConstraint {
+				return ErrShortIDTaken
+			}
 			return ErrUniqueViolation
 		}
 	}
 	return err
 }
 
-func (d *T) SelectByLongURL(ctx context.Context, longURL string) (Entity, error) {
-	row := d.Pool.QueryRow(ctx, "select * from urls where long_url = $1", longURL)
-	var e Entity
-	var rowID int
-	err := row.Scan(&rowID, &e.Deleted, &e.UserID, &e.ShortID, &e.LongURL)
+// shortIDConstraint ограничение уникальности short_id, созданное миграцией 0001
+const shortIDConstraint = "urls_short_id_key"
+
+// NextSequence следующий номер для генератора коротких ID
+func (d *T) NextSequence(ctx context.Context) (uint64, error) {
+	var n int64
+	err := d.Pool.QueryRow(ctx, "select nextval('short_id_seq')").Scan(&n)
+	return uint64(n), err
+}
+
+func (d *T) SelectByLongURL(ctx context.Context, userID string, longURL string) (Entity, error) {
+	row := d.Pool.QueryRow(ctx, "select "+entityColumns+" from urls where user_id = $1 and long_url = $2", userID, longURL)
+	e, err := scanEntity(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return e, ErrNotFound
+	}
 	return e, err
 }
 
 func (d *T) SelectByShortID(ctx context.Context, shortID string) (Entity, error) {
-	row := d.Pool.QueryRow(ctx, "select * from urls where short_id = $1", shortID)
-	var e Entity
-	var rowID int
-	err := row.Scan(&rowID, &e.Deleted, &e.UserID, &e.ShortID, &e.LongURL)
+	row := d.Pool.QueryRow(ctx, "select "+entityColumns+" from urls where short_id = $1", shortID)
+	e, err := scanEntity(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return e, ErrNotFound
+	}
 	return e, err
 }
 
+// SelectByShortIDs существующие записи с ID из shortIDs одним запросом; порядок не определен
+func (d *T) SelectByShortIDs(ctx context.Context, shortIDs []string) ([]Entity, error) {
+	rows, err := d.Pool.Query(ctx, "select "+entityColumns+" from urls where short_id = any($1)", shortIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	eArray := make([]Entity, 0, len(shortIDs))
+	for rows.Next() {
+		e, err := scanEntity(rows)
+		if err != nil {
+			return nil, err
+		}
+		eArray = append(eArray, e)
+	}
+	return eArray, rows.Err()
+}
+
 func (d *T) SelectByUser(ctx context.Context, userID string) ([]Entity, error) {
-	rows, err := d.Pool.Query(ctx, "select * from urls where user_id = $1", userID)
+	rows, err := d.Pool.Query(ctx, "select "+entityColumns+" from urls where user_id = $1", userID)
 	if err != nil {
 		return nil, err
 	}
-	var e Entity
-	var rowID int
+	defer rows.Close()
 	eArray := make([]Entity, 0, 10)
 	for rows.Next() {
-		err = rows.Scan(&rowID, &e.Deleted, &e.UserID, &e.ShortID, &e.LongURL)
+		e, err := scanEntity(rows)
 		if err != nil {
 			return nil, err
 		}
 		eArray = append(eArray, e)
 	}
-	return eArray, nil
-}
-
-//func (d *T) AddEntityBatch(ctx context.Context, userID string, data BatchInput) error {
-//	tx, err := d.Begin(ctx)
-//	if err != nil {
-//		return err
-//	}
-//	defer tx.Rollback(ctx)
-//
-//	stmt, err := tx.Prepare(ctx, "batch", "insert into urls(deleted, user_id, short_id, long_url) VALUES($1, $2, $3, $4)")
-//	if err != nil {
-//		return err
-//	}
-//
-//	for _, v := range data {
-//		if _, err = tx.Exec(ctx, stmt.Name, v.Deleted, userID, v.ShortID, v.OriginalURL); err != nil {
-//			return err
-//		}
-//	}
-//
-//	if err := tx.Commit(ctx); err != nil {
-//		return fmt.Errorf("unable to commit: %w", err)
-//	}
-//	return err
-//}
+	return eArray, rows.Err()
+}
 
+// AddEntityBatch добавление пакета записей в одной транзакции. Элементы с уже существующим
+// у пользователя длинным URL (в том числе повторяющиеся внутри пакета) не добавляются, а получают ID существующей записи
 func (d *T) AddEntityBatch(ctx context.Context, userID string, data BatchInput) error {
 	tx, err := d.Begin(ctx)
 	if err != nil {
@@ -133,52 +170,105 @@ func (d *T) AddEntityBatch(ctx context.Context, userID string, data BatchInput)
 	}
 	defer tx.Rollback(ctx)
 
-	stmt, err := tx.Prepare(ctx, "batch", "insert into urls values (default, $1, $2, $3, $4)")
-	if err != nil {
+	sql := "insert into urls (deleted, user_id, short_id, long_url) values ($1, $2, $3, $4) " +
+		"on conflict (user_id, long_url) do nothing returning short_id"
+	batch := &pgx.Batch{}
+	for _, v := range data {
+		batch.Queue(sql, v.Deleted, userID, v.ShortID, v.OriginalURL)
+	}
+	results := tx.SendBatch(ctx, batch)
+	existing := make([]string, 0)
+	for i := range data {
+		var shortID string
+		err = results.QueryRow().Scan(&shortID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			data[i].Status = BatchExists
+			existing = append(existing, data[i].OriginalURL)
+			continue
+		}
+		if err != nil {
+			_ = results.Close()
+			return uniqueViolation(err)
+		}
+		data[i].Status = BatchCreated
+	}
+	if err = results.Close(); err != nil {
 		return err
 	}
 
-	for _, v := range data {
-		if _, err = tx.Exec(ctx, stmt.Name, v.Deleted, userID, v.ShortID, v.OriginalURL); err != nil {
+	// ID существующих записей для дубликатов
+	if len(existing) > 0 {
+		sql = "select short_id, long_url from urls where user_id = $1 and long_url = any($2)"
+		rows, err := tx.Query(ctx, sql, userID, existing)
+		if err != nil {
+			return err
+		}
+		shortIDs := make(map[string]string, len(existing))
+		for rows.Next() {
+			var shortID, longURL string
+			if err = rows.Scan(&shortID, &longURL); err != nil {
+				rows.Close()
+				return err
+			}
+			shortIDs[longURL] = shortID
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
 			return err
 		}
+		for i := range data {
+			if data[i].Status == BatchExists {
+				data[i].ShortID = shortIDs[data[i].OriginalURL]
+			}
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("unable to commit: %w", err)
 	}
-	return err
+	return nil
 }
 
+// SetDeletedBatch пометка записей пользователя удаленными одним запросом
 func (d *T) SetDeletedBatch(ctx context.Context, userID string, shortIDs []string) error {
-	tx, err := d.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
+	sql := "update urls set deleted = true, deleted_at = now() where user_id = $1 and short_id = any($2) and not deleted"
+	_, err := d.Pool.Exec(ctx, sql, userID, shortIDs)
+	return err
+}
 
-	sql := "update urls set deleted = true where short_id = $1 and user_id = $2"
+func (d *T) SetDeleted(ctx context.Context, item pool.ToDeleteItem) error {
+	return d.SetDeletedBatch(ctx, item.UserID, []string{item.ShortID})
+}
 
-	stmt, err := tx.Prepare(ctx, "batchSetDeleted", sql)
+// RestoreBatch восстановление записей пользователя, удаленных не раньше deletedSince.
+// Возвращает ID восстановленных записей; чужие, неудаленные и несуществующие записи пропускаются
+func (d *T) RestoreBatch(ctx context.Context, userID string, shortIDs []string, deletedSince time.Time) ([]string, error) {
+	sql := "update urls set deleted = false, deleted_at = null " +
+		"where user_id = $1 and short_id = any($2) and deleted and deleted_at >= $3 returning short_id"
+	rows, err := d.Pool.Query(ctx, sql, userID, shortIDs, deletedSince)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	for _, shortID := range shortIDs {
-		_, err = tx.Exec(ctx, stmt.Name, shortID, userID)
-		if err != nil {
-			return err
+	defer rows.Close()
+	restored := make([]string, 0, len(shortIDs))
+	for rows.Next() {
+		var shortID string
+		if err = rows.Scan(&shortID); err != nil {
+			return nil, err
 		}
+		restored = append(restored, shortID)
 	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("unable to commit: %w", err)
-	}
-	return err
+	return restored, rows.Err()
 }
 
-func (d *T) SetDeleted(ctx context.Context, item pool.ToDeleteItem) error {
-	sql := "update urls set deleted = true where short_id = $1 and user_id = $2"
-	_, err := d.Pool.Exec(ctx, sql, item.ShortID, item.UserID)
-	return err
+// PurgeDeleted окончательное удаление не более limit записей, удаленных раньше deletedBefore.
+// Ограничение пакета не дает долго держать блокировки строк. Возвращает количество удаленных записей
+func (d *T) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	sql := "delete from urls where id in " +
+		"(select id from urls where deleted and deleted_at < $1 limit $2 for update skip locked)"
+	tag, err := d.Pool.Exec(ctx, sql, deletedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
 }
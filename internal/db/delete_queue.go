@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/jackc/pgx/v4"
+)
+
+// EnqueueDeletes запись элементов в постоянную очередь удаления
+func (d *T) EnqueueDeletes(ctx context.Context, items []pool.ToDeleteItem) error {
+	_, err := d.Pool.CopyFrom(ctx, pgx.Identifier{"delete_queue"}, []string{"user_id", "short_id"},
+		pgx.CopyFromSlice(len(items), func(i int) ([]interface{}, error) {
+			return []interface{}{items[i].UserID, items[i].ShortID}, nil
+		}))
+	return err
+}
+
+// PendingDeletes неподтвержденные элементы очереди удаления в порядке добавления
+func (d *T) PendingDeletes(ctx context.Context) ([]pool.ToDeleteItem, error) {
+	rows, err := d.Pool.Query(ctx, "select user_id, short_id from delete_queue order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]pool.ToDeleteItem, 0)
+	for rows.Next() {
+		var item pool.ToDeleteItem
+		if err = rows.Scan(&item.UserID, &item.ShortID); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// AckDeletes удаление обработанных элементов пользователя из очереди
+func (d *T) AckDeletes(ctx context.Context, userID string, shortIDs []string) error {
+	sql := "delete from delete_queue where user_id = $1 and short_id = any($2)"
+	_, err := d.Pool.Exec(ctx, sql, userID, shortIDs)
+	return err
+}
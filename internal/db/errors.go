@@ -0,0 +1,29 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+)
+
+// IsTransient временная ошибка, после которой запрос имеет смысл повторить: потеря соединения,
+// таймаут, откат транзакции из-за конфликта, нехватка ресурсов сервера. Ошибки в самом запросе
+// (синтаксис, ограничения, типы) постоянные
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:2] {
+		case "08", // connection exception
+			"40", // transaction rollback: serialization failure, deadlock
+			"53", // insufficient resources
+			"57": // operator intervention: admin shutdown, cannot connect now
+			return true
+		}
+		return false
+	}
+	// остальные ошибки возникают до получения ответа сервера: сеть, таймауты, закрытый пул
+	return true
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Файлы миграций: migrations/<версия>_<название>.up.sql и migrations/<версия>_<название>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID ключ pg_advisory_lock: миграции выполняет только один экземпляр сервиса
+const migrationLockID = 4839271665203001
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations список миграций, упорядоченный по версии
+func Migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		name := path.Base(file)
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction, name = "up", strings.TrimSuffix(name, ".up.sql")
+		case strings.HasSuffix(name, ".down.sql"):
+			direction, name = "down", strings.TrimSuffix(name, ".down.sql")
+		default:
+			return nil, fmt.Errorf("migration %s: unknown direction", file)
+		}
+		parts := strings.SplitN(name, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s: bad file name", file)
+		}
+		body, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrationStatus список миграций с отметкой о применении
+func (d *T) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := d.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		var err error
+		status, err = migrationStatus(ctx, conn)
+		return err
+	})
+	return status, err
+}
+
+// MigrateUp применение n очередных миграций, при n <= 0 - всех неприменённых
+func (d *T) MigrateUp(ctx context.Context, n int) ([]Migration, error) {
+	var applied []Migration
+	err := d.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		status, err := migrationStatus(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			if s.Applied {
+				continue
+			}
+			if n > 0 && len(applied) == n {
+				break
+			}
+			err = runMigration(ctx, conn, s.Migration, s.Up, "insert into schema_migrations (version, name) values ($1, $2)")
+			if err != nil {
+				return err
+			}
+			log.Printf("migration %d_%s applied\n", s.Version, s.Name)
+			applied = append(applied, s.Migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown откат n последних применённых миграций, при n <= 0 - всех
+func (d *T) MigrateDown(ctx context.Context, n int) ([]Migration, error) {
+	var reverted []Migration
+	err := d.withMigrationLock(ctx, func(conn *pgxpool.Conn) error {
+		status, err := migrationStatus(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(status) - 1; i >= 0; i-- {
+			s := status[i]
+			if !s.Applied {
+				continue
+			}
+			if n > 0 && len(reverted) == n {
+				break
+			}
+			if s.Down == "" {
+				return fmt.Errorf("migration %d_%s: no down script", s.Version, s.Name)
+			}
+			err = runMigration(ctx, conn, s.Migration, s.Down, "delete from schema_migrations where version = $1 and name = $2")
+			if err != nil {
+				return err
+			}
+			log.Printf("migration %d_%s reverted\n", s.Version, s.Name)
+			reverted = append(reverted, s.Migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// withMigrationLock выполнение fn на отдельном соединении под pg_advisory_lock
+func (d *T) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := d.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "select pg_advisory_lock($1)", migrationLockID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), "select pg_advisory_unlock($1)", migrationLockID)
+	}()
+
+	sql := "create table if not exists schema_migrations (" +
+		"version bigint primary key, " +
+		"name varchar(256) not null, " +
+		"applied_at timestamptz not null default now())"
+	_, err = conn.Exec(ctx, sql)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func migrationStatus(ctx context.Context, conn *pgxpool.Conn) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, "select version, applied_at from schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		status[i] = MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt}
+	}
+	return status, nil
+}
+
+// runMigration выполнение скрипта миграции и изменение schema_migrations в одной транзакции
+func runMigration(ctx context.Context, conn *pgxpool.Conn, m Migration, script string, bookkeeping string) error {
+	return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, script); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		_, err := tx.Exec(ctx, bookkeeping, m.Version, m.Name)
+		return err
+	})
+}
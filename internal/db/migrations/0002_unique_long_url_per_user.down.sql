@@ -0,0 +1,11 @@
+-- откат невозможен, пока один URL сокращен несколькими пользователями:
+-- данные не удаляются, дубликаты нужно разрешить вручную
+do $$
+begin
+    if exists (select 1 from urls group by long_url having count(*) > 1) then
+        raise exception 'cannot restore global unique long_url: urls contains duplicate long_url values';
+    end if;
+end
+$$;
+drop index if exists urls_user_id_long_url_key;
+alter table urls add constraint urls_long_url_key unique (long_url);
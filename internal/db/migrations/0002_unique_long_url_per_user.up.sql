@@ -0,0 +1,4 @@
+-- длинный URL уникален в пределах пользователя, а не глобально;
+-- существующие данные уникальны глобально, поэтому удовлетворяют новому ограничению
+alter table urls drop constraint if exists urls_long_url_key;
+create unique index if not exists urls_user_id_long_url_key on urls (user_id, long_url);
@@ -0,0 +1,8 @@
+-- постоянная очередь удаления: элементы хранятся до подтверждения обработки пулом удаления
+create table if not exists delete_queue (
+    id bigserial primary key,
+    user_id varchar(512) not null,
+    short_id varchar(512) not null,
+    created_at timestamptz not null default now()
+);
+create index if not exists delete_queue_user_id_short_id on delete_queue (user_id, short_id);
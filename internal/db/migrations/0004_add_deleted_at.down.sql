@@ -0,0 +1 @@
+alter table urls drop column if exists deleted_at;
@@ -0,0 +1,3 @@
+-- время удаления: после срока хранения удаленные записи удаляются окончательно
+alter table urls add column if not exists deleted_at timestamptz;
+update urls set deleted_at = now() where deleted and deleted_at is null;
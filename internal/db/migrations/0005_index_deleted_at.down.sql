@@ -0,0 +1 @@
+drop index if exists urls_deleted_at_key;
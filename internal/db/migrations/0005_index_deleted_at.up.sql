@@ -0,0 +1,2 @@
+-- поиск записей для окончательного удаления
+create index if not exists urls_deleted_at_key on urls (deleted_at) where deleted;
@@ -0,0 +1,2 @@
+drop trigger if exists urls_changed on urls;
+drop function if exists notify_urls_changed();
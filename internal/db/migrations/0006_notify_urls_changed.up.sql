@@ -0,0 +1,12 @@
+-- оповещение экземпляров сервиса об изменении и удалении записей для сброса их кэшей.
+-- Одинаковые оповещения в одной транзакции доставляются один раз
+create or replace function notify_urls_changed() returns trigger as $$
+begin
+    perform pg_notify('urls_changed', old.short_id);
+    return null;
+end;
+$$ language plpgsql;
+
+drop trigger if exists urls_changed on urls;
+create trigger urls_changed after update or delete on urls
+    for each row execute procedure notify_urls_changed();
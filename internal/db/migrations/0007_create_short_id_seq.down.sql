@@ -0,0 +1 @@
+drop sequence if exists short_id_seq;
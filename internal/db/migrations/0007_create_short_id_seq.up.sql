@@ -0,0 +1,2 @@
+-- номера для генератора коротких ID
+create sequence if not exists short_id_seq;
@@ -0,0 +1,16 @@
+-- оповещение и о добавлении записей: другие экземпляры сервиса сбрасывают отметку
+-- об отсутствии короткого ID в кэше, не дожидаясь ее истечения
+create or replace function notify_urls_changed() returns trigger as $$
+begin
+    if tg_op = 'INSERT' then
+        perform pg_notify('urls_changed', new.short_id);
+    else
+        perform pg_notify('urls_changed', old.short_id);
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+
+drop trigger if exists urls_changed on urls;
+create trigger urls_changed after insert or update or delete on urls
+    for each row execute procedure notify_urls_changed();
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"log"
+	"sync"
+	"time"
+)
+
+// ChangesChannel канал оповещений о добавлении, изменении и удалении записей urls, payload - короткий ID.
+// Оповещения отправляет триггер urls_changed
+const ChangesChannel = "urls_changed"
+
+// Listener подписка на оповещения ChangesChannel по отдельному соединению.
+// При потере соединения подписка восстанавливается
+type Listener struct {
+	url       string
+	opts      ListenerOptions
+	cancel    context.CancelFunc
+	done      chan struct{}
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// ListenerOptions обработчики оповещений и задержка повторного подключения
+type ListenerOptions struct {
+	OnChange func(shortID string)
+	// OnReconnect вызывается после каждой подписки, включая первую: оповещения,
+	// отправленные до подписки и за время без соединения, потеряны
+	OnReconnect   func()
+	RetryDelay    time.Duration // по умолчанию 100 мс
+	RetryMaxDelay time.Duration // по умолчанию 10 с
+}
+
+func NewListener(url string, opts ListenerOptions) *Listener {
+	if opts.OnChange == nil {
+		opts.OnChange = func(string) {}
+	}
+	if opts.OnReconnect == nil {
+		opts.OnReconnect = func() {}
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 100 * time.Millisecond
+	}
+	if opts.RetryMaxDelay <= 0 {
+		opts.RetryMaxDelay = 10 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{url: url, opts: opts, cancel: cancel, done: make(chan struct{}), ready: make(chan struct{})}
+	go l.run(ctx)
+	return l
+}
+
+// Ready канал закрывается после первой успешной подписки: с этого момента оповещения не теряются
+func (l *Listener) Ready() <-chan struct{} {
+	return l.ready
+}
+
+// Close остановка подписки
+func (l *Listener) Close() {
+	l.cancel()
+	<-l.done
+}
+
+func (l *Listener) run(ctx context.Context) {
+	defer close(l.done)
+	delay := l.opts.RetryDelay
+	for {
+		subscribed, err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if subscribed {
+			delay = l.opts.RetryDelay
+		}
+		log.Printf("listen %s: %v, reconnecting in %v\n", ChangesChannel, err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > l.opts.RetryMaxDelay {
+			delay = l.opts.RetryMaxDelay
+		}
+	}
+}
+
+// listen подписка и обработка оповещений до ошибки соединения
+func (l *Listener) listen(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, l.url)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err = conn.Exec(ctx, "listen "+ChangesChannel); err != nil {
+		return false, err
+	}
+	l.opts.OnReconnect()
+	l.readyOnce.Do(func() { close(l.ready) })
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return true, nil
+			}
+			return true, err
+		}
+		l.opts.OnChange(n.Payload)
+	}
+}
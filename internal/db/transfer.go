@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+)
+
+// InsertEntities добавление записей в одной транзакции без изменения существующих:
+// записи с занятым ID или длинным URL пользователя пропускаются. Возвращает количество добавленных записей
+func (d *T) InsertEntities(ctx context.Context, entities []Entity) (int, error) {
+	tx, err := d.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	sql := "insert into urls (" + entityColumns + ") values ($1, $2, $3, $4, $5) on conflict do nothing"
+	batch := &pgx.Batch{}
+	for _, e := range entities {
+		batch.Queue(sql, e.Deleted, e.UserID, e.ShortID, e.LongURL, e.DeletedAt)
+	}
+	results := tx.SendBatch(ctx, batch)
+	inserted := 0
+	for range entities {
+		tag, err := results.Exec()
+		if err != nil {
+			_ = results.Close()
+			return 0, err
+		}
+		inserted += int(tag.RowsAffected())
+	}
+	if err = results.Close(); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("unable to commit: %w", err)
+	}
+	return inserted, nil
+}
+
+// SelectPage не более limit записей с номером строки больше afterID в порядке добавления.
+// Возвращает номер последней строки для запроса следующей страницы
+func (d *T) SelectPage(ctx context.Context, afterID int64, limit int) ([]Entity, int64, error) {
+	sql := "select id, " + entityColumns + " from urls where id > $1 order by id limit $2"
+	rows, err := d.Pool.Query(ctx, sql, afterID, limit)
+	if err != nil {
+		return nil, afterID, err
+	}
+	defer rows.Close()
+	entities := make([]Entity, 0, limit)
+	lastID := afterID
+	for rows.Next() {
+		var e Entity
+		if err = rows.Scan(&lastID, &e.Deleted, &e.UserID, &e.ShortID, &e.LongURL, &e.DeletedAt); err != nil {
+			return nil, afterID, err
+		}
+		entities = append(entities, e)
+	}
+	return entities, lastID, rows.Err()
+}
+
+// Count количество записей
+func (d *T) Count(ctx context.Context) (int, error) {
+	var n int
+	err := d.Pool.QueryRow(ctx, "select count(*) from urls").Scan(&n)
+	return n, err
+}
@@ -0,0 +1,274 @@
+package dualwrite
+
+import (
+	"context"
+	"errors"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/handlers"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/purge"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/shortid"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// T хранилище на время переезда между двумя хранилищами: изменения записываются в оба,
+// чтение - из основного. Результат запроса определяет основное хранилище; ошибки и расхождения
+// запасного только подсчитываются и пишутся в лог
+type T struct {
+	primary   handlers.Repositorier
+	secondary handlers.Repositorier
+	opts      Options
+
+	secondaryErrors int64
+	fallbackReads   int64
+	mismatches      int64
+}
+
+// Options режим чтения
+type Options struct {
+	Fallback     bool // при отсутствии записи в основном хранилище искать в запасном
+	CompareReads bool // сверять прочитанные записи с запасным хранилищем
+}
+
+// Stats счетчики расхождений хранилищ
+type Stats struct {
+	SecondaryErrors int64 // ошибок записи в запасное хранилище
+	FallbackReads   int64 // записей, найденных только в запасном хранилище
+	Mismatches      int64 // записей, различающихся в хранилищах
+}
+
+func New(primary handlers.Repositorier, secondary handlers.Repositorier, opts Options) *T {
+	return &T{primary: primary, secondary: secondary, opts: opts}
+}
+
+func (d *T) Stats() Stats {
+	return Stats{
+		SecondaryErrors: atomic.LoadInt64(&d.secondaryErrors),
+		FallbackReads:   atomic.LoadInt64(&d.fallbackReads),
+		Mismatches:      atomic.LoadInt64(&d.mismatches),
+	}
+}
+
+// secondaryFailed учет ошибки запасного хранилища
+func (d *T) secondaryFailed(op string, err error) {
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&d.secondaryErrors, 1)
+	log.Printf("dual write: secondary %s: %v\n", op, err)
+}
+
+// compare сверка записи основного хранилища с запасным
+func (d *T) compare(ctx context.Context, e db.Entity) {
+	other, err := d.secondary.SelectByShortID(ctx, e.ShortID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		d.secondaryFailed("select", err)
+		return
+	}
+	if err != nil || !equal(e, other) {
+		atomic.AddInt64(&d.mismatches, 1)
+		log.Printf("dual write: entity %s differs: primary %+v, secondary %+v (%v)\n", e.ShortID, e, other, err)
+	}
+}
+
+// equal совпадение записей без учета времени удаления, которое каждое хранилище ставит само
+func equal(a db.Entity, b db.Entity) bool {
+	return a.ShortID == b.ShortID && a.UserID == b.UserID && a.LongURL == b.LongURL && a.Deleted == b.Deleted
+}
+
+func (d *T) AddEntity(ctx context.Context, entity db.Entity) error {
+	if err := d.primary.AddEntity(ctx, entity); err != nil {
+		return err
+	}
+	d.secondaryFailed("add", d.secondary.AddEntity(ctx, entity))
+	return nil
+}
+
+// AddEntityBatch добавление в основное хранилище, затем в запасное с ID, выданными основным
+func (d *T) AddEntityBatch(ctx context.Context, userID string, input db.BatchInput) error {
+	if err := d.primary.AddEntityBatch(ctx, userID, input); err != nil {
+		return err
+	}
+	mirror := make(db.BatchInput, len(input))
+	copy(mirror, input)
+	if err := d.secondary.AddEntityBatch(ctx, userID, mirror); err != nil {
+		d.secondaryFailed("add batch", err)
+		return nil
+	}
+	for i := range input {
+		if mirror[i].ShortID != input[i].ShortID {
+			atomic.AddInt64(&d.mismatches, 1)
+			log.Printf("dual write: user %s URL %s: primary ID %s, secondary ID %s\n",
+				userID, input[i].OriginalURL, input[i].ShortID, mirror[i].ShortID)
+		}
+	}
+	return nil
+}
+
+func (d *T) SelectByLongURL(ctx context.Context, userID string, longURL string) (db.Entity, error) {
+	e, err := d.primary.SelectByLongURL(ctx, userID, longURL)
+	if errors.Is(err, db.ErrNotFound) && d.opts.Fallback {
+		return d.fallback(d.secondary.SelectByLongURL(ctx, userID, longURL))
+	}
+	if err == nil && d.opts.CompareReads {
+		d.compare(ctx, e)
+	}
+	return e, err
+}
+
+func (d *T) SelectByShortID(ctx context.Context, shortID string) (db.Entity, error) {
+	e, err := d.primary.SelectByShortID(ctx, shortID)
+	if errors.Is(err, db.ErrNotFound) && d.opts.Fallback {
+		return d.fallback(d.secondary.SelectByShortID(ctx, shortID))
+	}
+	if err == nil && d.opts.CompareReads {
+		d.compare(ctx, e)
+	}
+	return e, err
+}
+
+// fallback результат чтения из запасного хранилища вместо ненайденной в основном записи
+func (d *T) fallback(e db.Entity, err error) (db.Entity, error) {
+	if err == nil {
+		atomic.AddInt64(&d.fallbackReads, 1)
+		log.Printf("dual write: entity %s found only in secondary\n", e.ShortID)
+		return e, nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		d.secondaryFailed("select", err)
+	}
+	return db.Entity{}, db.ErrNotFound
+}
+
+func (d *T) SelectByShortIDs(ctx context.Context, shortIDs []string) ([]db.Entity, error) {
+	selection, err := d.primary.SelectByShortIDs(ctx, shortIDs)
+	if err != nil || !d.opts.Fallback || len(selection) == len(shortIDs) {
+		return selection, err
+	}
+
+	// записи, которых нет в основном хранилище
+	found := make(map[string]struct{}, len(selection))
+	for _, e := range selection {
+		found[e.ShortID] = struct{}{}
+	}
+	missing := make([]string, 0, len(shortIDs)-len(selection))
+	for _, shortID := range shortIDs {
+		if _, ok := found[shortID]; !ok {
+			missing = append(missing, shortID)
+		}
+	}
+	other, err := d.secondary.SelectByShortIDs(ctx, missing)
+	if err != nil {
+		d.secondaryFailed("select", err)
+		return selection, nil
+	}
+	atomic.AddInt64(&d.fallbackReads, int64(len(other)))
+	return append(selection, other...), nil
+}
+
+func (d *T) SelectByUser(ctx context.Context, userID string) ([]db.Entity, error) {
+	selection, err := d.primary.SelectByUser(ctx, userID)
+	if err != nil || !d.opts.Fallback {
+		return selection, err
+	}
+
+	// записи, которых нет в основном хранилище
+	other, err := d.secondary.SelectByUser(ctx, userID)
+	if err != nil {
+		d.secondaryFailed("select by user", err)
+		return selection, nil
+	}
+	found := make(map[string]struct{}, len(selection))
+	for _, e := range selection {
+		found[e.ShortID] = struct{}{}
+	}
+	for _, e := range other {
+		if _, ok := found[e.ShortID]; !ok {
+			atomic.AddInt64(&d.fallbackReads, 1)
+			selection = append(selection, e)
+		}
+	}
+	return selection, nil
+}
+
+func (d *T) Ping(ctx context.Context) error {
+	if err := d.primary.Ping(ctx); err != nil {
+		return err
+	}
+	d.secondaryFailed("ping", d.secondary.Ping(ctx))
+	return nil
+}
+
+func (d *T) SetDeletedBatch(ctx context.Context, userID string, shortIDs []string) error {
+	if err := d.primary.SetDeletedBatch(ctx, userID, shortIDs); err != nil {
+		return err
+	}
+	d.secondaryFailed("delete", d.secondary.SetDeletedBatch(ctx, userID, shortIDs))
+	return nil
+}
+
+func (d *T) SetDeleted(ctx context.Context, item pool.ToDeleteItem) error {
+	return d.SetDeletedBatch(ctx, item.UserID, []string{item.ShortID})
+}
+
+func (d *T) RestoreBatch(ctx context.Context, userID string, shortIDs []string, deletedSince time.Time) ([]string, error) {
+	restored, err := d.primary.RestoreBatch(ctx, userID, shortIDs, deletedSince)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.secondary.RestoreBatch(ctx, userID, shortIDs, deletedSince)
+	d.secondaryFailed("restore", err)
+	return restored, nil
+}
+
+// PurgeDeleted окончательное удаление в обоих хранилищах, если они его поддерживают.
+// Возвращается количество записей, удаленных в основном хранилище
+func (d *T) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	n := 0
+	if purger, ok := d.primary.(purge.Purger); ok {
+		var err error
+		if n, err = purger.PurgeDeleted(ctx, deletedBefore, limit); err != nil {
+			return n, err
+		}
+	}
+	if purger, ok := d.secondary.(purge.Purger); ok {
+		_, err := purger.PurgeDeleted(ctx, deletedBefore, limit)
+		d.secondaryFailed("purge", err)
+	}
+	return n, nil
+}
+
+// NextSequence номер последовательности коротких ID основного хранилища, а если ее там нет - запасного
+func (d *T) NextSequence(ctx context.Context) (uint64, error) {
+	for _, repo := range []handlers.Repositorier{d.primary, d.secondary} {
+		if seq, ok := repo.(shortid.Sequence); ok {
+			return seq.NextSequence(ctx)
+		}
+	}
+	return 0, errors.New("dual write: no short ID sequence in either storage")
+}
+
+// Постоянная очередь удаления ведется только в основном хранилище
+
+func (d *T) EnqueueDeletes(ctx context.Context, items []pool.ToDeleteItem) error {
+	if journal, ok := d.primary.(pool.Journal); ok {
+		return journal.EnqueueDeletes(ctx, items)
+	}
+	return nil
+}
+
+func (d *T) PendingDeletes(ctx context.Context) ([]pool.ToDeleteItem, error) {
+	if journal, ok := d.primary.(pool.Journal); ok {
+		return journal.PendingDeletes(ctx)
+	}
+	return nil, nil
+}
+
+func (d *T) AckDeletes(ctx context.Context, userID string, shortIDs []string) error {
+	if journal, ok := d.primary.(pool.Journal); ok {
+		return journal.AckDeletes(ctx, userID, shortIDs)
+	}
+	return nil
+}
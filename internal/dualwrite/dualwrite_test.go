@@ -0,0 +1,78 @@
+package dualwrite
+
+import (
+	"context"
+	"errors"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/repository"
+	"testing"
+)
+
+func newMemory(t *testing.T) *repository.Repository {
+	repo, err := repository.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+func TestDualWrite(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := newMemory(t), newMemory(t)
+	d := New(primary, secondary, Options{Fallback: true, CompareReads: true})
+
+	// изменения записываются в оба хранилища
+	e := db.Entity{UserID: "user", ShortID: "1", LongURL: "https://habr.com/"}
+	if err := d.AddEntity(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	batch := db.BatchInput{{CorrelationID: "0", OriginalURL: "https://yandex.ru/", ShortID: "2"}}
+	if err := d.AddEntityBatch(ctx, "user", batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetDeletedBatch(ctx, "user", []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, shortID := range []string{"1", "2"} {
+		p, err1 := primary.SelectByShortID(ctx, shortID)
+		s, err2 := secondary.SelectByShortID(ctx, shortID)
+		if err1 != nil || err2 != nil || !equal(p, s) {
+			t.Fatalf("entity %s: primary %v %v, secondary %v %v", shortID, p, err1, s, err2)
+		}
+	}
+
+	// запись, которой нет в основном хранилище, читается из запасного
+	only := db.Entity{UserID: "user", ShortID: "3", LongURL: "https://golang.org/"}
+	if err := secondary.AddEntity(ctx, only); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.SelectByShortID(ctx, only.ShortID)
+	if err != nil || got != only {
+		t.Fatalf("fallback: %v, %v", got, err)
+	}
+	selection, err := d.SelectByUser(ctx, "user")
+	if err != nil || len(selection) != 3 {
+		t.Fatalf("select by user: %v, %v", selection, err)
+	}
+
+	// расхождение при чтении подсчитывается, результат - из основного хранилища
+	diverged := db.Entity{UserID: "user", ShortID: "4", LongURL: "https://ya.ru/"}
+	if err = primary.AddEntity(ctx, diverged); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = d.SelectByShortID(ctx, diverged.ShortID); err != nil || got != diverged {
+		t.Fatalf("primary read: %v, %v", got, err)
+	}
+
+	stats := d.Stats()
+	if stats != (Stats{FallbackReads: 2, Mismatches: 1}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// без Fallback запись из запасного хранилища не видна
+	d = New(primary, secondary, Options{})
+	if _, err = d.SelectByShortID(ctx, only.ShortID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
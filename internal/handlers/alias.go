@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ограничения длины пользовательского короткого ID
+const (
+	aliasMinLength = 3
+	aliasMaxLength = 64
+)
+
+// reservedAliases первые сегменты путей NewRouter: такой короткий ID не открывался бы через GET /{id}
+var reservedAliases = map[string]struct{}{
+	"api":  {},
+	"user": {},
+	"ping": {},
+}
+
+// validateAlias проверка пользовательского короткого ID: латинские буквы, цифры, '-' и '_',
+// длина от aliasMinLength до aliasMaxLength, не зарезервированное слово
+func validateAlias(alias string) error {
+	if len(alias) < aliasMinLength || len(alias) > aliasMaxLength {
+		return fmt.Errorf("alias %q: length must be from %d to %d", alias, aliasMinLength, aliasMaxLength)
+	}
+	for _, c := range alias {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("alias %q: only latin letters, digits, '-' and '_' are allowed", alias)
+		}
+	}
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return fmt.Errorf("alias %q is reserved", alias)
+	}
+	return nil
+}
+
+// aliasTakenError пользовательский короткий ID уже занят
+type aliasTakenError string
+
+func (e aliasTakenError) Error() string {
+	return fmt.Sprintf("alias %q is already taken", string(e))
+}
+
+// aliasNotApplied причина, по которой пользовательский ID не применен к уже сокращенному URL
+func aliasNotApplied(alias string) string {
+	return fmt.Sprintf("alias %q is not applied: URL is already shortened", alias)
+}
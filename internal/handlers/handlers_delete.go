@@ -1,15 +1,39 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"github.com/go-chi/chi/v5"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type shortIDList []string
 
+type deleteJobResponse struct {
+	JobID string `json:"job_id"`
+}
+
+// deleteJobStatus ход удаления: количество элементов по состояниям и состояние каждого короткого ID
+type deleteJobStatus struct {
+	JobID  string          `json:"job_id"`
+	Queued int             `json:"queued"`
+	Done   int             `json:"done"`
+	Failed int             `json:"failed"`
+	Items  []deleteJobItem `json:"items"`
+}
+
+type deleteJobItem struct {
+	ShortID string `json:"id"`
+	Status  string `json:"status"` // queued, done или failed
+}
+
 func handlerDelete(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userIDBytes, err := getUserID(r)
@@ -18,7 +42,6 @@ func handlerDelete(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
 			return
 		}
 		userID := userIDBytes.String()
-		_ = userID
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -33,10 +56,128 @@ func handlerDelete(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
 			return
 		}
 
-		for _, shortID := range shortIDs {
-			cfgApp.DeleterChan <- pool.ToDeleteItem{UserID: userID, ShortID: shortID}
+		// элементы записываются в постоянную очередь до ответа клиенту; при заполненной очереди
+		// запрос не ждет, а отклоняется с предложением повторить позже
+		jobID, err := cfgApp.DeleterPool.Submit(r.Context(), userID, shortIDs)
+		switch {
+		case errors.Is(err, pool.ErrQueueFull), errors.Is(err, pool.ErrClosed):
+			retryAfter := int(math.Ceil(cfgApp.DeleteFlushInterval.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, pool.ErrTooManyItems):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
+		js, err := json.Marshal(deleteJobResponse{JobID: jobID})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/user/urls/jobs/"+jobID)
 		w.WriteHeader(http.StatusAccepted)
+		_, err = w.Write(js)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handlerDeleteJob ход удаления по ID задания. Задание доступно только его владельцу
+func handlerDeleteJob(cfgApp cfg.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getUserID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		job, ok := cfgApp.DeleterPool.Job(userID.String(), chi.URLParam(r, "id"))
+		if !ok {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+
+		status := deleteJobStatus{
+			JobID:  job.ID,
+			Queued: job.Count(pool.JobQueued),
+			Done:   job.Count(pool.JobDone),
+			Failed: job.Count(pool.JobFailed),
+			Items:  make([]deleteJobItem, len(job.Items)),
+		}
+		for i, item := range job.Items {
+			status.Items[i] = deleteJobItem{ShortID: item.ShortID, Status: item.Status}
+		}
+		js, err := json.Marshal(status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(js)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handlerRestore восстановление удаленных записей пользователя, срок хранения которых не истек.
+// В ответе - ID восстановленных записей
+func handlerRestore(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getUserID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var shortIDs shortIDList
+		err = json.Unmarshal(body, &shortIDs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var deletedSince time.Time
+		if cfgApp.DeleteGracePeriod > 0 {
+			deletedSince = time.Now().Add(-cfgApp.DeleteGracePeriod)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfgApp.CtxTimeout)*time.Second)
+		defer cancel()
+		restored, err := repo.RestoreBatch(ctx, userID.String(), shortIDs, deletedSince)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		js, err := json.Marshal(shortIDList(restored))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(js)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 }
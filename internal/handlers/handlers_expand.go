@@ -3,9 +3,13 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/shortid"
 	"github.com/go-chi/chi/v5"
 	"net/http"
+	"sort"
 	"time"
 )
 
@@ -20,7 +24,11 @@ func handlerExpandURL(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
 		id := chi.URLParam(r, "id")
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfgApp.CtxTimeout)*time.Second)
 		defer cancel()
-		entity, err := repo.SelectByShortID(ctx, id)
+		entity, err := selectByShortID(ctx, repo, cfgApp, id)
+		if errors.Is(err, db.ErrNotFound) {
+			handleNotFound(ctx, w, repo, cfgApp, id)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -36,6 +44,78 @@ func handlerExpandURL(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
 	}
 }
 
+type responseNotFound struct {
+	Error      string   `json:"error"`
+	DidYouMean []string `json:"did_you_mean"`
+}
+
+// maxSuggestCandidates предел количества соседних ID, проверяемых одним запросом
+const maxSuggestCandidates = 64
+
+// checkedIDs генератор, если короткие ID выдаются с контрольным символом: опечатки в них можно найти
+func checkedIDs(cfgApp cfg.Config) (shortid.Checked, bool) {
+	gen, ok := cfgApp.IDGenerator.(shortid.Checked)
+	return gen, ok
+}
+
+// selectByShortID поиск записи по ID, а если ее нет - по ID с замененными похожими символами
+func selectByShortID(ctx context.Context, repo Repositorier, cfgApp cfg.Config, id string) (db.Entity, error) {
+	entity, err := repo.SelectByShortID(ctx, id)
+	if _, ok := checkedIDs(cfgApp); !errors.Is(err, db.ErrNotFound) || !ok {
+		return entity, err
+	}
+	if normalized := shortid.Normalize(id); normalized != id && shortid.Valid(normalized) {
+		return repo.SelectByShortID(ctx, normalized)
+	}
+	return entity, err
+}
+
+// handleNotFound ответ 400 на ненайденный ID. Для ID с несовпавшим контрольным символом
+// сообщается об опечатке; существующие ID, отличающиеся одной опечаткой, предлагаются в ответе
+func handleNotFound(ctx context.Context, w http.ResponseWriter, repo Repositorier, cfgApp cfg.Config, id string) {
+	gen, ok := checkedIDs(cfgApp)
+	if !ok {
+		http.Error(w, db.ErrNotFound.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg := db.ErrNotFound.Error()
+	normalized := shortid.Normalize(id)
+	if shortid.IsChecked(normalized) && !shortid.Valid(normalized) {
+		msg = "the ID has a typo: check character does not match"
+	}
+	var suggestions []string
+	// соседние ID ищутся только для ID длины, которую выдает генератор: поиск растет
+	// с квадратом длины, а маршрут принимает ID любой длины
+	if cfgApp.ShortIDSuggest && len(normalized) == gen.Length() {
+		candidates := shortid.Neighbors(normalized)
+		if len(candidates) > maxSuggestCandidates {
+			candidates = candidates[:maxSuggestCandidates]
+		}
+		// все соседние ID проверяются одним запросом; при ошибке ответ без предложений
+		selection, _ := repo.SelectByShortIDs(ctx, candidates)
+		for _, e := range selection {
+			if !e.Deleted {
+				suggestions = append(suggestions, cfgApp.BaseURL+"/"+e.ShortID)
+			}
+		}
+		sort.Strings(suggestions)
+	}
+	if len(suggestions) == 0 {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	js, err := json.Marshal(responseNotFound{Error: msg, DidYouMean: suggestions})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(js)
+}
+
 func handlerUserHistory(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := getUserID(r)
@@ -4,26 +4,33 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/cfg"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
-	"github.com/google/uuid"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/longurl"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/shortid"
 	"io"
+	"log"
 	"net/http"
 	"time"
 )
 
 type requestURL struct {
-	URL string `json:"url"`
+	URL   string `json:"url"`
+	Alias string `json:"alias,omitempty"` // короткий ID, выбранный пользователем
 }
 
 type responseURL struct {
 	Result string `json:"result"`
+	Error  string `json:"error,omitempty"` // причина, по которой выбранный ID не применен
 }
 
 type batchOutput []batchOutputItem
 type batchOutputItem struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
+	Status        string `json:"status"`          // created или exists
+	Error         string `json:"error,omitempty"` // причина, по которой выбранный ID не применен
 }
 
 func handlerShortenURLJSONAPI(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
@@ -50,19 +57,39 @@ func handlerShortenURLJSONAPI(repo Repositorier, cfgApp cfg.Config) http.Handler
 			http.Error(w, `no key "url" or empty request`, http.StatusBadRequest)
 			return
 		}
-
-		shortID := uuid.NewString() // ID короткого URL
+		if longURL.URL, err = canonicalURL(cfgApp, longURL.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = checkPolicy(cfgApp, userID.String(), longURL.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if longURL.Alias != "" {
+			if err = validateAlias(longURL.Alias); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
 		// запрос в БД на сохранение URL. Замена id на существующий в случае дублирования longURL
 		var statusCode = http.StatusCreated
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfgApp.CtxTimeout)*time.Second)
 		defer cancel()
-		err = repo.AddEntity(ctx, db.Entity{UserID: userID.String(), ShortID: shortID, LongURL: longURL.URL})
+		shortID, err := addEntity(ctx, repo, cfgApp, userID.String(), longURL.URL, longURL.Alias)
+		if errors.Is(err, db.ErrShortIDTaken) && longURL.Alias != "" {
+			http.Error(w, aliasTakenError(longURL.Alias).Error(), http.StatusConflict)
+			return
+		}
+		var aliasErr string
 		if errors.Is(err, db.ErrUniqueViolation) {
 			var e db.Entity
-			e, err = repo.SelectByLongURL(ctx, longURL.URL)
+			e, err = repo.SelectByLongURL(ctx, userID.String(), longURL.URL)
 			shortID = e.ShortID
 			statusCode = http.StatusConflict
+			if longURL.Alias != "" && longURL.Alias != shortID {
+				aliasErr = aliasNotApplied(longURL.Alias)
+			}
 		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -70,7 +97,7 @@ func handlerShortenURLJSONAPI(repo Repositorier, cfgApp cfg.Config) http.Handler
 		}
 
 		// Ответ на запрос
-		response := responseURL{Result: cfgApp.BaseURL + "/" + shortID}
+		response := responseURL{Result: cfgApp.BaseURL + "/" + shortID, Error: aliasErr}
 		jsonResponse, err := json.Marshal(response)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -101,18 +128,24 @@ func handlerShortenURL(repo Repositorier, cfgApp cfg.Config) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		longURL := string(body)
-
-		shortID := uuid.NewString()
+		longURL, err := canonicalURL(cfgApp, string(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = checkPolicy(cfgApp, userID.String(), longURL); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 
 		// запрос в БД на сохранение URL. Замена id на существующий в случае дублирования longURL
 		var statusCode = http.StatusCreated
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfgApp.CtxTimeout)*time.Second)
 		defer cancel()
-		err = repo.AddEntity(ctx, db.Entity{UserID: userID.String(), ShortID: shortID, LongURL: longURL})
+		shortID, err := addEntity(ctx, repo, cfgApp, userID.String(), longURL, "")
 		if errors.Is(err, db.ErrUniqueViolation) {
 			var e db.Entity
-			e, err = repo.SelectByLongURL(ctx, longURL)
+			e, err = repo.SelectByLongURL(ctx, userID.String(), longURL)
 			shortID = e.ShortID
 			statusCode = http.StatusConflict
 		}
@@ -155,23 +188,66 @@ func handlerShortenURLAPIBatch(repo Repositorier, cfgApp cfg.Config) http.Handle
 			return
 		}
 
-		// generate ID's for short URL's
 		for i := range input {
-			input[i].ShortID = uuid.NewString()
+			if input[i].OriginalURL, err = canonicalURL(cfgApp, input[i].OriginalURL); err != nil {
+				http.Error(w, fmt.Sprintf("correlation_id %s: %v", input[i].CorrelationID, err), http.StatusBadRequest)
+				return
+			}
+			if err = checkPolicy(cfgApp, userID.String(), input[i].OriginalURL); err != nil {
+				http.Error(w, fmt.Sprintf("correlation_id %s: %v", input[i].CorrelationID, err), http.StatusForbidden)
+				return
+			}
+		}
+
+		aliases := make(map[string]struct{})
+		for _, v := range input {
+			if v.Alias == "" {
+				continue
+			}
+			if err = validateAlias(v.Alias); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, ok := aliases[v.Alias]; ok {
+				http.Error(w, fmt.Sprintf("alias %q is repeated in the batch", v.Alias), http.StatusBadRequest)
+				return
+			}
+			aliases[v.Alias] = struct{}{}
 		}
 
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(cfgApp.CtxTimeout)*time.Second)
 		defer cancel()
-		err = repo.AddEntityBatch(ctx, userID.String(), input)
+
+		// пакет с занятым пользовательским ID не добавляется
+		err = addEntityBatch(ctx, repo, cfgApp, userID.String(), input)
+		var taken aliasTakenError
+		if errors.As(err, &taken) {
+			http.Error(w, taken.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		// 201 - все URL добавлены, 409 - все уже существовали, 207 - часть добавлена
 		output := make(batchOutput, len(input))
+		created := 0
 		for i := range input {
 			output[i].ShortURL = cfgApp.BaseURL + "/" + input[i].ShortID
 			output[i].CorrelationID = input[i].CorrelationID
+			output[i].Status = input[i].Status
+			if input[i].Status == db.BatchCreated {
+				created++
+			} else if input[i].Alias != "" && input[i].Alias != input[i].ShortID {
+				output[i].Error = aliasNotApplied(input[i].Alias)
+			}
+		}
+		var statusCode = http.StatusCreated
+		if created == 0 && len(input) > 0 {
+			statusCode = http.StatusConflict
+		} else if created < len(input) {
+			statusCode = http.StatusMultiStatus
 		}
 
 		jsonResponse, err := json.Marshal(output)
@@ -182,7 +258,7 @@ func handlerShortenURLAPIBatch(repo Repositorier, cfgApp cfg.Config) http.Handle
 
 		w.Header().Set("Content-Type", "application/json")
 		setCookie(w, userID)
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(statusCode)
 		_, err = w.Write(jsonResponse)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -191,6 +267,100 @@ func handlerShortenURLAPIBatch(repo Repositorier, cfgApp cfg.Config) http.Handle
 	}
 }
 
+// canonicalURL проверка длинного URL и приведение его к единому виду для поиска дубликатов
+func canonicalURL(cfgApp cfg.Config, raw string) (string, error) {
+	return longurl.Canonicalize(raw, longurl.Options{MaxLength: cfgApp.URLMaxLength, StripTracking: cfgApp.URLStripTracking})
+}
+
+// checkPolicy проверка длинного URL по политике, если она задана. Отказ пишется в лог
+func checkPolicy(cfgApp cfg.Config, userID string, longURL string) error {
+	if cfgApp.Policy == nil {
+		return nil
+	}
+	err := cfgApp.Policy.Check(longURL)
+	if err != nil {
+		log.Printf("policy: user %s, URL %s: %v\n", userID, longURL, err)
+	}
+	return err
+}
+
+// maxIDAttempts попыток добавления записи, если сгенерированный ID уже занят
+const maxIDAttempts = 5
+
+func idGenerator(cfgApp cfg.Config) shortid.IDGenerator {
+	if cfgApp.IDGenerator == nil {
+		return shortid.UUID{}
+	}
+	return cfgApp.IDGenerator
+}
+
+// addEntity добавление записи с ID alias, а если он не задан - с новым ID.
+// Если новый ID уже занят, запись добавляется с другим ID
+func addEntity(ctx context.Context, repo Repositorier, cfgApp cfg.Config, userID string, longURL string, alias string) (string, error) {
+	if alias != "" {
+		return alias, repo.AddEntity(ctx, db.Entity{UserID: userID, ShortID: alias, LongURL: longURL})
+	}
+	gen := idGenerator(cfgApp)
+	var err error
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		var shortID string
+		if shortID, err = gen.NewID(ctx); err != nil {
+			return "", err
+		}
+		err = repo.AddEntity(ctx, db.Entity{UserID: userID, ShortID: shortID, LongURL: longURL})
+		if !errors.Is(err, db.ErrShortIDTaken) {
+			return shortID, err
+		}
+	}
+	return "", err
+}
+
+// addEntityBatch добавление пакета с новыми ID для элементов без Alias. Если какой-либо ID уже занят,
+// пакет не добавляется и повторяется с другими ID; занятый Alias возвращается как aliasTakenError без повторов
+func addEntityBatch(ctx context.Context, repo Repositorier, cfgApp cfg.Config, userID string, input db.BatchInput) error {
+	gen := idGenerator(cfgApp)
+	var err error
+	for attempt := 0; attempt < maxIDAttempts; attempt++ {
+		if attempt > 0 {
+			if err = checkAliases(ctx, repo, input); err != nil {
+				return err
+			}
+		}
+		for i := range input {
+			input[i].Status = ""
+			if input[i].Alias != "" {
+				input[i].ShortID = input[i].Alias
+				continue
+			}
+			if input[i].ShortID, err = gen.NewID(ctx); err != nil {
+				return err
+			}
+		}
+		err = repo.AddEntityBatch(ctx, userID, input)
+		if !errors.Is(err, db.ErrShortIDTaken) {
+			return err
+		}
+	}
+	return err
+}
+
+// checkAliases aliasTakenError, если какой-либо Alias пакета уже занят
+func checkAliases(ctx context.Context, repo Repositorier, input db.BatchInput) error {
+	for _, v := range input {
+		if v.Alias == "" {
+			continue
+		}
+		_, err := repo.SelectByShortID(ctx, v.Alias)
+		if err == nil {
+			return aliasTakenError(v.Alias)
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
 func handlerPingDB(repo Repositorier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := repo.Ping(r.Context())
@@ -7,17 +7,20 @@ import (
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"time"
 )
 
 type Repositorier interface {
 	AddEntity(ctx context.Context, entity db.Entity) error
-	SelectByLongURL(ctx context.Context, longURL string) (db.Entity, error)
+	SelectByLongURL(ctx context.Context, userID string, longURL string) (db.Entity, error)
 	SelectByShortID(ctx context.Context, shortURL string) (db.Entity, error)
+	SelectByShortIDs(ctx context.Context, shortIDs []string) ([]db.Entity, error)
 	SelectByUser(ctx context.Context, userID string) ([]db.Entity, error)
 	AddEntityBatch(ctx context.Context, userID string, input db.BatchInput) error
 	Ping(ctx context.Context) error
 	SetDeletedBatch(ctx context.Context, userID string, shortIDs []string) error
 	SetDeleted(ctx context.Context, item pool.ToDeleteItem) error
+	RestoreBatch(ctx context.Context, userID string, shortIDs []string, deletedSince time.Time) ([]string, error)
 }
 
 func NewRouter(repo Repositorier, cfgApp cfg.Config) chi.Router {
@@ -43,6 +46,8 @@ func NewRouter(repo Repositorier, cfgApp cfg.Config) chi.Router {
 		r.Get("/ping", handlerPingDB(repo))
 		r.Post("/api/shorten/batch", handlerShortenURLAPIBatch(repo, cfgApp))
 		r.Delete("/api/user/urls", handlerDelete(repo, cfgApp))
+		r.Get("/api/user/urls/jobs/{id}", handlerDeleteJob(cfgApp))
+		r.Post("/api/user/urls/restore", handlerRestore(repo, cfgApp))
 	})
 	return r
 }
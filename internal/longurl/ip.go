@@ -0,0 +1,72 @@
+package longurl
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// errInvalidIPv4 хост похож на IPv4-адрес, но не разбирается
+var errInvalidIPv4 = errors.New("invalid IPv4 address")
+
+// HostIP IP-адрес хоста URL или nil, если хост - доменное имя. Кроме обычной записи, IPv4 разбирается
+// так же, как браузеры и inet_aton: 1-4 части, каждая десятичная, восьмеричная (с ведущим 0)
+// или шестнадцатеричная (0x), последняя часть занимает оставшиеся байты адреса:
+// 2130706433, 0177.0.0.1, 127.1 и 0x7f.1 - это 127.0.0.1
+func HostIP(hostname string) (net.IP, error) {
+	if strings.Contains(hostname, ":") {
+		if ip := net.ParseIP(hostname); ip != nil {
+			return ip, nil
+		}
+		return nil, nil
+	}
+	parts := strings.Split(strings.TrimSuffix(hostname, "."), ".")
+	if _, ok := parseIPv4Part(parts[len(parts)-1]); !ok {
+		return nil, nil
+	}
+	if len(parts) > 4 {
+		return nil, errInvalidIPv4
+	}
+
+	var addr uint64
+	for i, part := range parts {
+		n, ok := parseIPv4Part(part)
+		if !ok {
+			return nil, errInvalidIPv4
+		}
+		if i < len(parts)-1 {
+			if n > 255 {
+				return nil, errInvalidIPv4
+			}
+			addr |= n << (8 * (3 - i))
+			continue
+		}
+		if n >= 1<<(8*(4-i)) {
+			return nil, errInvalidIPv4
+		}
+		addr |= n
+	}
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr)).To4(), nil
+}
+
+// parseIPv4Part число части IPv4-адреса в десятичной, восьмеричной или шестнадцатеричной записи
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case part == "":
+		return 0, false
+	case len(part) >= 2 && (part[:2] == "0x" || part[:2] == "0X"):
+		part, base = part[2:], 16
+		if part == "" {
+			return 0, true
+		}
+	case len(part) >= 2 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+	n, err := strconv.ParseUint(part, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,142 @@
+package longurl
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalid длинный URL нельзя сократить; текст ошибки объясняет причину
+var ErrInvalid = errors.New("invalid URL")
+
+// Options ограничения и очистка длинного URL
+type Options struct {
+	MaxLength     int  // по умолчанию 1024 - размер столбца long_url
+	StripTracking bool // удалять параметры отслеживания: utm_*, fbclid, gclid и подобные
+}
+
+// trackingParams параметры запроса, не влияющие на содержимое страницы
+var trackingParams = map[string]struct{}{
+	"fbclid":    {},
+	"gclid":     {},
+	"dclid":     {},
+	"yclid":     {},
+	"msclkid":   {},
+	"igshid":    {},
+	"mc_cid":    {},
+	"mc_eid":    {},
+	"_openstat": {},
+}
+
+// Canonicalize проверка и приведение длинного URL к единому виду, чтобы одинаковые адреса
+// совпадали при поиске дубликатов: схема и хост в нижнем регистре, национальный домен
+// в punycode, IP-адрес в стандартной записи, без порта по умолчанию, пустой путь заменяется на "/".
+// Путь и параметры запроса в остальном сохраняются как есть
+func Canonicalize(raw string, opts Options) (string, error) {
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = 1024
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("%w: URL is empty", ErrInvalid)
+	}
+	if len(raw) > opts.MaxLength {
+		return "", fmt.Errorf("%w: URL is longer than %d characters", ErrInvalid, opts.MaxLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%w: URL must be absolute with http or https scheme", ErrInvalid)
+	}
+	host, err := canonicalHost(u)
+	if err != nil {
+		return "", err
+	}
+	u.Host = host
+	if u.Path == "" && u.RawPath == "" {
+		u.Path = "/"
+	}
+	if opts.StripTracking {
+		u.RawQuery = stripTracking(u.RawQuery)
+		u.ForceQuery = false
+	}
+
+	canonical := u.String()
+	if len(canonical) > opts.MaxLength {
+		return "", fmt.Errorf("%w: URL is longer than %d characters", ErrInvalid, opts.MaxLength)
+	}
+	return canonical, nil
+}
+
+// canonicalHost хост в нижнем регистре и punycode или IP-адрес в стандартной записи,
+// без порта по умолчанию для схемы
+func canonicalHost(u *url.URL) (string, error) {
+	hostname, port := u.Hostname(), u.Port()
+	if hostname == "" {
+		return "", fmt.Errorf("%w: URL has no host", ErrInvalid)
+	}
+	ip, err := HostIP(hostname)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("%w: host %q: %v", ErrInvalid, hostname, err)
+	case ip == nil:
+		if hostname, err = ASCIIHost(hostname); err != nil {
+			return "", err
+		}
+	case ip.To4() != nil:
+		hostname = ip.To4().String()
+	default:
+		hostname = "[" + ip.String() + "]"
+	}
+	if u.Scheme == "http" && port == "80" || u.Scheme == "https" && port == "443" {
+		port = ""
+	}
+	if port != "" {
+		return hostname + ":" + port, nil
+	}
+	return hostname, nil
+}
+
+// hostProfile IDNA без правил STD3: подчеркивания в именах хостов (my_host.example.com)
+// встречаются на практике и открываются браузерами
+var hostProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.Transitional(false))
+
+// ASCIIHost доменное имя в нижнем регистре и punycode. Кроме букв, цифр и '-' допускается только '_'
+func ASCIIHost(hostname string) (string, error) {
+	ascii, err := hostProfile.ToASCII(strings.TrimSuffix(hostname, "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: host %q: %v", ErrInvalid, hostname, err)
+	}
+	for _, c := range ascii {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", fmt.Errorf("%w: host %q: invalid character %q", ErrInvalid, hostname, c)
+		}
+	}
+	return ascii, nil
+}
+
+// stripTracking удаление параметров отслеживания без изменения записи остальных параметров
+func stripTracking(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, p := range params {
+		key := strings.SplitN(p, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = strings.ToLower(unescaped)
+		}
+		if _, ok := trackingParams[key]; ok || strings.HasPrefix(key, "utm_") {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,72 @@
+package longurl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		raw  string
+		opts Options
+		want string
+	}{
+		{raw: "https://habr.com/ru/all/", want: "https://habr.com/ru/all/"},
+		{raw: "  HTTPS://Habr.COM:443/ru/All/ ", want: "https://habr.com/ru/All/"},
+		{raw: "http://habr.com:80", want: "http://habr.com/"},
+		{raw: "http://habr.com:8080/a", want: "http://habr.com:8080/a"},
+		{raw: "https://пример.рф/путь", want: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{raw: "http://[::1]:80/", want: "http://[::1]/"},
+		{raw: "http://[0:0:0:0:0:0:0:1]/", want: "http://[::1]/"},
+		{raw: "http://[2001:DB8::1]:8080/", want: "http://[2001:db8::1]:8080/"},
+		{raw: "http://[::ffff:127.0.0.1]/", want: "http://127.0.0.1/"},
+		{raw: "http://2130706433/", want: "http://127.0.0.1/"},
+		{raw: "http://0177.0.0.1/", want: "http://127.0.0.1/"},
+		{raw: "http://127.1/", want: "http://127.0.0.1/"},
+		{raw: "http://0x7f.1/", want: "http://127.0.0.1/"},
+		{raw: "http://0X7F.0.0.0x1./", want: "http://127.0.0.1/"},
+		{raw: "https://My_Host.Example.com/", want: "https://my_host.example.com/"},
+		{raw: "https://1.2.example/", want: "https://1.2.example/"},
+		{raw: "https://yandex.ru/maps/?ll=39.580041%2C43.713351&z=9.98", want: "https://yandex.ru/maps/?ll=39.580041%2C43.713351&z=9.98"},
+		{raw: "https://habr.com/?utm_source=tg&id=1", want: "https://habr.com/?utm_source=tg&id=1"},
+		{
+			raw:  "https://habr.com/?utm_source=tg&id=1&FBCLID=x&q=a%2Cb#top",
+			opts: Options{StripTracking: true},
+			want: "https://habr.com/?id=1&q=a%2Cb#top",
+		},
+		{raw: "https://habr.com/?utm_medium=x", opts: Options{StripTracking: true}, want: "https://habr.com/"},
+	}
+	for _, tt := range tests {
+		got, err := Canonicalize(tt.raw, tt.opts)
+		if err != nil || got != tt.want {
+			t.Errorf("Canonicalize(%q) = %q, %v; want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestCanonicalizeInvalid(t *testing.T) {
+	tests := []struct {
+		raw    string
+		reason string
+	}{
+		{raw: "", reason: "empty"},
+		{raw: "habr.com/ru", reason: "absolute"},
+		{raw: "ftp://habr.com/", reason: "http or https"},
+		{raw: "https:///path", reason: "no host"},
+		{raw: "http://habr.com:port/", reason: "invalid port"},
+		{raw: "https://habr.com/" + strings.Repeat("a", 1024), reason: "longer than 1024"},
+		{raw: "https://bad_host!.com/", reason: "host"},
+		{raw: "http://256.0.0.1/", reason: "invalid IPv4"},
+		{raw: "http://1.2.3.4.5/", reason: "invalid IPv4"},
+		{raw: "http://127.16777216/", reason: "invalid IPv4"},
+		{raw: "http://08.0.0.1/", reason: "invalid IPv4"},
+		{raw: "http://example.1/", reason: "invalid IPv4"},
+	}
+	for _, tt := range tests {
+		_, err := Canonicalize(tt.raw, Options{})
+		if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tt.reason) {
+			t.Errorf("Canonicalize(%q): expected error about %q, got %v", tt.raw, tt.reason, err)
+		}
+	}
+}
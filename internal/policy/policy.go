@@ -0,0 +1,223 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/longurl"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRejected длинный URL запрещено сокращать; текст ошибки содержит причину
+var ErrRejected = errors.New("URL is not allowed")
+
+// Rules правила файла политики:
+//
+//	{
+//	  "schemes": ["https"],
+//	  "allow_domains": ["example.com"],
+//	  "block_domains": ["phishing.example.com", "localhost", "zip"],
+//	  "block_cidrs": ["10.0.0.0/8", "127.0.0.0/8", "::1/128"]
+//	}
+//
+// Домен в списке относится и ко всем его поддоменам, поэтому "zip" запрещает всю зону.
+// Национальные домены можно записывать как есть: "пример.рф" сравнивается с хостом в punycode.
+// Запрет сильнее разрешения; при непустом allow_domains разрешены только перечисленные домены.
+// block_cidrs проверяются для URL с IP-адресом вместо хоста в любой записи, которую принимает браузер.
+// Пустой schemes - любая схема
+type Rules struct {
+	Schemes      []string `json:"schemes"`
+	AllowDomains []string `json:"allow_domains"`
+	BlockDomains []string `json:"block_domains"`
+	BlockCIDRs   []string `json:"block_cidrs"`
+}
+
+// rulesT правила, подготовленные для проверки
+type rulesT struct {
+	schemes      map[string]struct{}
+	allowDomains map[string]struct{}
+	blockDomains map[string]struct{}
+	blockNets    []*net.IPNet
+}
+
+// T политика из файла, перечитываемого при изменении
+type T struct {
+	fileName string
+
+	lock    sync.RWMutex
+	rules   rulesT
+	modTime time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New загрузка правил из файла и проверка его изменений раз в interval (0 - без перечитывания)
+func New(fileName string, interval time.Duration) (*T, error) {
+	p := &T{fileName: fileName, done: make(chan struct{})}
+	if _, err := p.reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		p.wg.Add(1)
+		go p.watch(interval)
+	}
+	return p, nil
+}
+
+func (p *T) Close() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *T) watch(interval time.Duration) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			// при ошибке в файле действуют прежние правила
+			if reloaded, err := p.reload(); err != nil {
+				log.Printf("policy %s: %v, keeping previous rules\n", p.fileName, err)
+			} else if reloaded {
+				log.Printf("policy %s reloaded\n", p.fileName)
+			}
+		}
+	}
+}
+
+// reload чтение файла, если он изменился после прошлого чтения
+func (p *T) reload() (bool, error) {
+	info, err := os.Stat(p.fileName)
+	if err != nil {
+		return false, err
+	}
+	p.lock.RLock()
+	unchanged := info.ModTime().Equal(p.modTime)
+	p.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(p.fileName)
+	if err != nil {
+		return false, err
+	}
+	var rules Rules
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return false, err
+	}
+	compiled, err := compile(rules)
+	if err != nil {
+		return false, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.rules, p.modTime = compiled, info.ModTime()
+	return true, nil
+}
+
+func compile(rules Rules) (rulesT, error) {
+	compiled := rulesT{schemes: toSet(rules.Schemes)}
+	var err error
+	if compiled.allowDomains, err = domainSet(rules.AllowDomains); err != nil {
+		return rulesT{}, err
+	}
+	if compiled.blockDomains, err = domainSet(rules.BlockDomains); err != nil {
+		return rulesT{}, err
+	}
+	for _, cidr := range rules.BlockCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return rulesT{}, err
+		}
+		compiled.blockNets = append(compiled.blockNets, ipNet)
+	}
+	return compiled, nil
+}
+
+// domainSet домены в punycode, как хосты URL после longurl.Canonicalize: "пример.рф" - это "xn--e1afmkfd.xn--p1ai"
+func domainSet(domains []string) (map[string]struct{}, error) {
+	set := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		ascii, err := longurl.ASCIIHost(strings.Trim(domain, "."))
+		if err != nil || ascii == "" {
+			return nil, fmt.Errorf("bad domain %q: %v", domain, err)
+		}
+		set[ascii] = struct{}{}
+	}
+	return set, nil
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[strings.Trim(strings.ToLower(v), ".")] = struct{}{}
+	}
+	return set
+}
+
+// Check проверка приведенного к единому виду длинного URL по действующим правилам
+func (p *T) Check(longURL string) error {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	p.lock.RLock()
+	rules := p.rules
+	p.lock.RUnlock()
+
+	if len(rules.schemes) > 0 {
+		if _, ok := rules.schemes[u.Scheme]; !ok {
+			return fmt.Errorf("%w: scheme %s is not allowed", ErrRejected, u.Scheme)
+		}
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	// адрес разбирается, как в браузере: 2130706433 и 127.1 - это 127.0.0.1
+	ip, err := longurl.HostIP(host)
+	if err != nil {
+		return fmt.Errorf("%w: host %s: %v", ErrRejected, host, err)
+	}
+	if ip != nil {
+		for _, ipNet := range rules.blockNets {
+			if ipNet.Contains(ip) {
+				return fmt.Errorf("%w: address %s is in blocked network %s", ErrRejected, ip, ipNet)
+			}
+		}
+	}
+	if domain, ok := matchDomain(rules.blockDomains, host); ok {
+		return fmt.Errorf("%w: domain %s is blocked", ErrRejected, domain)
+	}
+	if len(rules.allowDomains) > 0 {
+		if _, ok := matchDomain(rules.allowDomains, host); !ok {
+			return fmt.Errorf("%w: host %s is not in the allowed domains", ErrRejected, host)
+		}
+	}
+	return nil
+}
+
+// matchDomain домен из списка, совпадающий с хостом или являющийся его родительским доменом
+func matchDomain(domains map[string]struct{}, host string) (string, bool) {
+	for domain := host; domain != ""; {
+		if _, ok := domains[domain]; ok {
+			return domain, true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return "", false
+}
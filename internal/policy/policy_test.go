@@ -0,0 +1,135 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRules(t *testing.T, fileName string, rules string, modTime time.Time) {
+	if err := os.WriteFile(fileName, []byte(rules), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fileName, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "policy.json")
+	writeRules(t, fileName, `{
+		"schemes": ["https"],
+		"block_domains": ["phishing.example.com", "localhost", "zip", "Пример.РФ"],
+		"block_cidrs": ["10.0.0.0/8", "127.0.0.0/8", "::1/128"]
+	}`, time.Now())
+	p, err := New(fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	tests := []struct {
+		url    string
+		reason string // пусто - URL разрешен
+	}{
+		{url: "https://example.com/"},
+		{url: "https://example.com.phishing.org/"},
+		{url: "http://example.com/", reason: "scheme http"},
+		{url: "https://phishing.example.com/login", reason: "domain phishing.example.com"},
+		{url: "https://a.b.phishing.example.com/", reason: "domain phishing.example.com"},
+		{url: "https://localhost:8080/", reason: "domain localhost"},
+		{url: "https://github.zip/", reason: "domain zip"},
+		{url: "https://10.1.2.3/admin", reason: "blocked network 10.0.0.0/8"},
+		{url: "https://[::1]/", reason: "blocked network ::1/128"},
+		{url: "https://192.168.0.1/"},
+		{url: "https://xn--e1afmkfd.xn--p1ai/", reason: "domain xn--e1afmkfd.xn--p1ai"},
+		{url: "https://shop.xn--e1afmkfd.xn--p1ai/", reason: "domain xn--e1afmkfd.xn--p1ai"},
+		{url: "https://10.1/", reason: "blocked network 10.0.0.0/8"},
+		{url: "https://167772161/", reason: "address 10.0.0.1 is in blocked network 10.0.0.0/8"},
+		{url: "https://012.0.0.1/", reason: "blocked network 10.0.0.0/8"},
+		{url: "https://0xa.1/", reason: "blocked network 10.0.0.0/8"},
+		{url: "https://[::ffff:10.0.0.1]/", reason: "blocked network 10.0.0.0/8"},
+		{url: "https://256.1/", reason: "invalid IPv4"},
+		{url: "https://2130706433/", reason: "address 127.0.0.1 is in blocked network 127.0.0.0/8"},
+		{url: "https://0177.0.0.1/", reason: "address 127.0.0.1 is in blocked network 127.0.0.0/8"},
+		{url: "https://127.1/", reason: "address 127.0.0.1 is in blocked network 127.0.0.0/8"},
+		{url: "https://0x7f.1/", reason: "address 127.0.0.1 is in blocked network 127.0.0.0/8"},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.url)
+		if tt.reason == "" && err != nil {
+			t.Errorf("%s: unexpected rejection %v", tt.url, err)
+		}
+		if tt.reason != "" && (!errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), tt.reason)) {
+			t.Errorf("%s: expected rejection with %q, got %v", tt.url, tt.reason, err)
+		}
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "policy.json")
+	writeRules(t, fileName, `{"allow_domains": ["example.com"], "block_domains": ["bad.example.com"]}`, time.Now())
+	p, err := New(fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err = p.Check("https://docs.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Check("https://example.org/"); !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+	// запрет сильнее разрешения
+	if err = p.Check("https://bad.example.com/"); !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "policy.json")
+	start := time.Now().Add(-time.Hour)
+	writeRules(t, fileName, `{"block_domains": ["example.com"]}`, start)
+	p, err := New(fileName, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err = p.Check("https://example.com/"); err == nil {
+		t.Fatal("expected rejection")
+	}
+
+	// новые правила применяются без перезапуска
+	writeRules(t, fileName, `{"block_domains": ["example.org"]}`, start.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Check("https://example.com/") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("rules are not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = p.Check("https://example.org/"); err == nil {
+		t.Fatal("expected rejection")
+	}
+
+	// при ошибке в файле действуют прежние правила
+	writeRules(t, fileName, `{"block_cidrs": ["not a network"]}`, start.Add(2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if err = p.Check("https://example.org/"); err == nil {
+		t.Fatal("expected previous rules to stay")
+	}
+
+	if _, err = New(fileName, 0); err == nil {
+		t.Fatal("expected error for invalid rules")
+	}
+	for _, rules := range []string{`{"block_domains": ["bad domain!"]}`, `{"allow_domains": ["."]}`} {
+		writeRules(t, fileName, rules, start.Add(3*time.Minute))
+		if _, err = New(fileName, 0); err == nil {
+			t.Fatalf("expected error for %s", rules)
+		}
+	}
+}
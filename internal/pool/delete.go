@@ -2,67 +2,460 @@ package pool
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"golang.org/x/sync/errgroup"
+	"github.com/google/uuid"
 	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type DeleterPoolT struct {
-	Input chan ToDeleteItem
-	g     *errgroup.Group
-	ctx   context.Context
-	ErrCh chan error
+	Input  chan ToDeleteItem
+	ctx    context.Context
+	cancel context.CancelFunc // аварийная остановка: обработчики бросают необработанные элементы
+	wg     sync.WaitGroup
+	opts   Options
+
+	inputLock  sync.RWMutex // отправка в Input под RLock, закрытие Input под Lock
+	closed     bool
+	submitLock sync.Mutex // проверка свободного места в Input и его резервирование
+	reserved   int        // места в Input, зарезервированные Submit до записи в постоянную очередь
+	jobs       jobsT
+
+	// счетчики элементов: удалено, не удалено (в списке неудаленных), брошено при остановке
+	deleted   int64
+	failed    int64
+	abandoned int64
+
+	deadLock    sync.Mutex
+	deadLetters []DeadLetter
 }
 
 type ToDeleteItem struct {
 	UserID  string
 	ShortID string
+	JobID   string // задание Submit; пустое для элементов, повторенных после перезапуска
+}
+
+// DeadLetter элемент, который не удалось удалить: постоянная ошибка или исчерпаны попытки
+type DeadLetter struct {
+	Item ToDeleteItem
+	Err  string
+	At   time.Time
+}
+
+// ErrClosed пул остановлен и не принимает элементы
+var ErrClosed = errors.New("deleter pool is closed")
+
+// ErrQueueFull в Input нет места для всех элементов Submit; запрос можно повторить позже
+var ErrQueueFull = errors.New("delete queue is full")
+
+// ErrTooManyItems элементов Submit больше размера Input; такой запрос не будет принят никогда
+var ErrTooManyItems = errors.New("too many items to delete")
+
+// DrainStats результат остановки пула: сколько элементов удалено, не удалено и брошено
+// за время остановки. Брошенные элементы остаются в постоянной очереди и будут удалены после перезапуска
+type DrainStats struct {
+	Flushed   int64
+	Failed    int64
+	Abandoned int64
 }
 
+// Deleter хранилище, в котором удаление выполняется одним запросом на пакет ID пользователя
 type Deleter interface {
-	SetDeleted(ctx context.Context, item ToDeleteItem) error
+	SetDeletedBatch(ctx context.Context, userID string, shortIDs []string) error
+}
+
+// Journal постоянная очередь удаления. Элементы записываются в очередь до ответа клиенту
+// и подтверждаются после обработки; неподтвержденные элементы повторяются после перезапуска
+type Journal interface {
+	EnqueueDeletes(ctx context.Context, items []ToDeleteItem) error
+	PendingDeletes(ctx context.Context) ([]ToDeleteItem, error)
+	AckDeletes(ctx context.Context, userID string, shortIDs []string) error
+}
+
+// Options параметры пула. Нулевые значения заменяются значениями по умолчанию
+type Options struct {
+	Workers       int           // количество обработчиков
+	BufferSize    int           // размер буфера Input
+	BatchSize     int           // размер пакета ID пользователя, при котором пакет отправляется сразу
+	FlushInterval time.Duration // период отправки неполных пакетов
+
+	MaxAttempts    int                  // попыток удаления пакета, включая первую
+	RetryDelay     time.Duration        // задержка перед первым повтором, далее удваивается
+	RetryMaxDelay  time.Duration        // предельная задержка между повторами
+	DeadLetterSize int                  // сколько последних неудаленных элементов хранить
+	IsTransient    func(err error) bool // временная ошибка, после которой удаление повторяется
+	Journal        Journal              // постоянная очередь; по умолчанию - хранилище, если оно реализует Journal
+	JobHistorySize int                  // сколько последних заданий Submit хранить для Job
+}
+
+func (o *Options) setDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = 1000
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = 100 * time.Millisecond
+	}
+	if o.RetryMaxDelay < o.RetryDelay {
+		o.RetryMaxDelay = 100 * o.RetryDelay
+	}
+	if o.JobHistorySize <= 0 {
+		o.JobHistorySize = 10000
+	}
+	if o.DeadLetterSize <= 0 {
+		o.DeadLetterSize = 10000
+	}
+	if o.IsTransient == nil {
+		o.IsTransient = func(err error) bool { return !errors.Is(err, context.Canceled) }
+	}
+}
+
+func New(ctx context.Context, repo Deleter) *DeleterPoolT {
+	return NewWithOptions(ctx, repo, Options{})
 }
 
-func New(ctx context.Context, repo Deleter) DeleterPoolT {
-	input := make(chan ToDeleteItem, 1000)
-	g, ctx := errgroup.WithContext(ctx)
-	errCh := make(chan error)
-	pool := DeleterPoolT{
-		Input: input,
-		g:     g,
-		ctx:   ctx,
-		ErrCh: errCh,
+// NewWithOptions создание и запуск пула. Неподтвержденные элементы постоянной очереди,
+// оставшиеся от предыдущего запуска, отправляются на удаление повторно
+func NewWithOptions(ctx context.Context, repo Deleter, opts Options) *DeleterPoolT {
+	opts.setDefaults()
+	if opts.Journal == nil {
+		opts.Journal, _ = repo.(Journal)
 	}
-	go pool.Run(repo)
+	pool := &DeleterPoolT{
+		Input: make(chan ToDeleteItem, opts.BufferSize),
+		opts:  opts,
+		jobs:  newJobs(opts.JobHistorySize),
+	}
+	pool.ctx, pool.cancel = context.WithCancel(ctx)
+	pool.Run(repo)
 	return pool
 }
 
-func (p DeleterPoolT) Run(repo Deleter) {
-	numWorkers := 4
-
-	for i := 0; i < numWorkers; i++ {
-		p.g.Go(func() error {
-			for {
-				select {
-				case item := <-p.Input:
-					err := repo.SetDeleted(p.ctx, item)
-					if err != nil {
-						return err
-					}
-				case <-p.ctx.Done():
-					//log.Println("deleter worker has stopped")
-					return nil
+func (p *DeleterPoolT) Run(repo Deleter) {
+	for i := 0; i < p.opts.Workers; i++ {
+		p.wg.Add(1)
+		go p.supervise(i, repo)
+	}
+	if p.opts.Journal != nil {
+		p.wg.Add(1)
+		go p.replay()
+	}
+}
+
+// Submit запись элементов пользователя в постоянную очередь и передача обработчикам без ожидания.
+// Возвращает ID задания, по которому Job сообщает о ходе удаления. После успешного возврата элементы
+// будут удалены, даже если сервис остановится раньше. Если в Input нет места, возвращается ErrQueueFull,
+// после остановки пула - ErrClosed
+func (p *DeleterPoolT) Submit(ctx context.Context, userID string, shortIDs []string) (string, error) {
+	if len(shortIDs) > cap(p.Input) {
+		return "", ErrTooManyItems
+	}
+	p.inputLock.RLock()
+	defer p.inputLock.RUnlock()
+	if p.closed {
+		return "", ErrClosed
+	}
+
+	// Input заполняют только Submit и replay с учетом резерва, поэтому зарезервированное место
+	// останется свободным, пока элементы пишутся в постоянную очередь без блокировки
+	if !p.reserve(len(shortIDs)) {
+		return "", ErrQueueFull
+	}
+	defer p.release(len(shortIDs))
+
+	jobID := uuid.NewString()
+	items := make([]ToDeleteItem, len(shortIDs))
+	for i, shortID := range shortIDs {
+		items[i] = ToDeleteItem{UserID: userID, ShortID: shortID, JobID: jobID}
+	}
+	if p.opts.Journal != nil && len(items) > 0 {
+		if err := p.opts.Journal.EnqueueDeletes(ctx, items); err != nil {
+			return "", err
+		}
+	}
+	p.jobs.add(jobID, userID, shortIDs)
+	for _, item := range items {
+		p.Input <- item
+	}
+	return jobID, nil
+}
+
+// reserve резервирование n мест в Input; false, если свободного места не хватает
+func (p *DeleterPoolT) reserve(n int) bool {
+	p.submitLock.Lock()
+	defer p.submitLock.Unlock()
+	if cap(p.Input)-len(p.Input)-p.reserved < n {
+		return false
+	}
+	p.reserved += n
+	return true
+}
+
+// release снятие резерва после отправки элементов в Input или ошибки записи в постоянную очередь
+func (p *DeleterPoolT) release(n int) {
+	p.submitLock.Lock()
+	defer p.submitLock.Unlock()
+	p.reserved -= n
+}
+
+// Job состояние задания Submit пользователя userID; false, если задание не найдено,
+// вытеснено из истории или принадлежит другому пользователю
+func (p *DeleterPoolT) Job(userID string, jobID string) (Job, bool) {
+	return p.jobs.get(userID, jobID)
+}
+
+// replay повторная отправка неподтвержденных элементов постоянной очереди
+func (p *DeleterPoolT) replay() {
+	defer p.wg.Done()
+	items, err := p.opts.Journal.PendingDeletes(p.ctx)
+	if err != nil {
+		log.Printf("deleter pool: unable to read delete queue: %v\n", err)
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+	log.Printf("deleter pool: replaying %d pending items\n", len(items))
+	// по одному элементу без ожидания, чтобы не задерживать Submit и остановку пула
+	for i := 0; i < len(items); {
+		p.inputLock.RLock()
+		if p.closed {
+			p.inputLock.RUnlock()
+			return
+		}
+		p.submitLock.Lock()
+		sent := len(p.Input)+p.reserved < cap(p.Input)
+		if sent {
+			p.Input <- items[i]
+			i++
+		}
+		p.submitLock.Unlock()
+		p.inputLock.RUnlock()
+		if !sent && !p.sleep(p.opts.FlushInterval/10+time.Millisecond) {
+			return
+		}
+	}
+}
+
+// supervise перезапуск обработчика после сбоя (паники) с нарастающей задержкой
+func (p *DeleterPoolT) supervise(id int, repo Deleter) {
+	defer p.wg.Done()
+	delay := p.opts.RetryDelay
+	for {
+		err := p.work(repo)
+		if err == nil {
+			return
+		}
+		log.Printf("deleter worker %d has failed: %v; restarting in %v\n", id, err, delay)
+		if !p.sleep(delay) {
+			return
+		}
+		delay = nextDelay(delay, p.opts.RetryMaxDelay)
+	}
+}
+
+// work накопление ID по пользователям и удаление пакетами: при достижении BatchSize
+// или по истечении FlushInterval. После закрытия Input накопленные пакеты удаляются,
+// при аварийной остановке - бросаются. Возвращает ошибку только при панике
+func (p *DeleterPoolT) work(repo Deleter) (err error) {
+	batches := make(map[string][]ToDeleteItem)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			for userID, items := range batches {
+				p.deadLetter(userID, items, err)
+				p.ack(userID, items)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(p.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case item, ok := <-p.Input:
+			if !ok {
+				for userID, items := range batches {
+					p.flush(repo, userID, items)
+					delete(batches, userID)
 				}
+				return nil
+			}
+			batches[item.UserID] = append(batches[item.UserID], item)
+			if len(batches[item.UserID]) >= p.opts.BatchSize {
+				p.flush(repo, item.UserID, batches[item.UserID])
+				delete(batches, item.UserID)
+			}
+		case <-ticker.C:
+			for userID, items := range batches {
+				p.flush(repo, userID, items)
+				delete(batches, userID)
 			}
+		case <-p.ctx.Done():
+			for userID, items := range batches {
+				atomic.AddInt64(&p.abandoned, int64(len(items)))
+				delete(batches, userID)
+			}
+			return nil
+		}
+	}
+}
+
+// flush удаление пакета с повторами при временных ошибках. Пакет, который не удалось удалить,
+// попадает в список неудаленных. Обработанный пакет подтверждается в постоянной очереди,
+// кроме пакетов, прерванных аварийной остановкой пула
+func (p *DeleterPoolT) flush(repo Deleter, userID string, items []ToDeleteItem) {
+	shortIDs := make([]string, len(items))
+	for i, item := range items {
+		shortIDs[i] = item.ShortID
+	}
+	delay := p.opts.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := repo.SetDeletedBatch(p.ctx, userID, shortIDs)
+		if err == nil {
+			atomic.AddInt64(&p.deleted, int64(len(items)))
+			p.jobs.finish(items, JobDone)
+			p.ack(userID, items)
+			return
+		}
+		if p.ctx.Err() != nil {
+			atomic.AddInt64(&p.abandoned, int64(len(items)))
+			return
+		}
+		if !p.opts.IsTransient(err) || attempt >= p.opts.MaxAttempts {
+			p.deadLetter(userID, items, fmt.Errorf("attempt %d: %w", attempt, err))
+			p.ack(userID, items)
+			return
+		}
+		// полный разброс задержки, чтобы обработчики не повторяли запросы одновременно
+		if !p.sleep(time.Duration(rand.Int63n(int64(delay)) + 1)) {
+			atomic.AddInt64(&p.abandoned, int64(len(items)))
+			return
+		}
+		delay = nextDelay(delay, p.opts.RetryMaxDelay)
+	}
+}
+
+func (p *DeleterPoolT) deadLetter(userID string, items []ToDeleteItem, err error) {
+	log.Printf("deleter pool: %d items of user %s were not deleted: %v\n", len(items), userID, err)
+	atomic.AddInt64(&p.failed, int64(len(items)))
+	p.jobs.finish(items, JobFailed)
+	p.deadLock.Lock()
+	defer p.deadLock.Unlock()
+	now := time.Now()
+	for _, item := range items {
+		p.deadLetters = append(p.deadLetters, DeadLetter{
+			Item: item,
+			Err:  err.Error(),
+			At:   now,
 		})
 	}
+	if extra := len(p.deadLetters) - p.opts.DeadLetterSize; extra > 0 {
+		p.deadLetters = append(p.deadLetters[:0], p.deadLetters[extra:]...)
+	}
+}
+
+// ack подтверждение обработки пакета в постоянной очереди. При ошибке пакет будет
+// повторен после перезапуска, что безопасно: повторное удаление ничего не меняет
+func (p *DeleterPoolT) ack(userID string, items []ToDeleteItem) {
+	if p.opts.Journal == nil {
+		return
+	}
+	shortIDs := make([]string, len(items))
+	for i, item := range items {
+		shortIDs[i] = item.ShortID
+	}
+	if err := p.opts.Journal.AckDeletes(p.ctx, userID, shortIDs); err != nil {
+		log.Printf("deleter pool: unable to ack %d items of user %s: %v\n", len(items), userID, err)
+	}
+}
+
+// DeadLetters копия списка неудаленных элементов, от старых к новым
+func (p *DeleterPoolT) DeadLetters() []DeadLetter {
+	p.deadLock.Lock()
+	defer p.deadLock.Unlock()
+	return append([]DeadLetter(nil), p.deadLetters...)
+}
+
+// sleep ожидание d; false, если пул остановлен раньше
+func (p *DeleterPoolT) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+func nextDelay(delay, maxDelay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// Shutdown остановка пула: прием элементов прекращается, оставшиеся элементы удаляются
+// до истечения ctx, после чего обработчики останавливаются аварийно
+func (p *DeleterPoolT) Shutdown(ctx context.Context) DrainStats {
+	deleted, failed := atomic.LoadInt64(&p.deleted), atomic.LoadInt64(&p.failed)
+	abandoned := atomic.LoadInt64(&p.abandoned)
+
+	drained := make(chan struct{})
+	go func() {
+		p.inputLock.Lock()
+		if !p.closed {
+			p.closed = true
+			close(p.Input)
+		}
+		p.inputLock.Unlock()
+		p.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Printf("deleter pool: drain deadline exceeded: %v\n", ctx.Err())
+		p.cancel()
+		<-drained
+	}
+	p.cancel()
+
+	// элементы, оставшиеся в Input после аварийной остановки
+	for range p.Input {
+		atomic.AddInt64(&p.abandoned, 1)
+	}
 
-	if err := p.g.Wait(); err != nil {
-		p.ErrCh <- fmt.Errorf("error in deleter pool: %w", err)
+	stats := DrainStats{
+		Flushed:   atomic.LoadInt64(&p.deleted) - deleted,
+		Failed:    atomic.LoadInt64(&p.failed) - failed,
+		Abandoned: atomic.LoadInt64(&p.abandoned) - abandoned,
 	}
+	log.Printf("deleter pool has closed: %d items flushed, %d failed, %d abandoned\n",
+		stats.Flushed, stats.Failed, stats.Abandoned)
+	return stats
 }
 
-func (p DeleterPoolT) Close() {
-	_ = p.g.Wait()
-	log.Println("deleter pool has closed")
+// Close остановка пула с удалением всех оставшихся элементов
+func (p *DeleterPoolT) Close() {
+	p.Shutdown(context.Background())
 }
@@ -0,0 +1,369 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type deleteCall struct {
+	userID   string
+	shortIDs []string
+}
+
+// fakeDeleter запоминает успешные вызовы; ошибки из failures возвращаются по очереди
+// перед успешными вызовами, значение errPanic вызывает панику
+type fakeDeleter struct {
+	lock     sync.Mutex
+	calls    []deleteCall
+	failures []error
+	attempts int
+}
+
+var errPanic = errors.New("panic")
+
+func (f *fakeDeleter) SetDeletedBatch(_ context.Context, userID string, shortIDs []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.attempts++
+	if len(f.failures) > 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		if err == errPanic {
+			panic("deleter failure")
+		}
+		return err
+	}
+	f.calls = append(f.calls, deleteCall{userID: userID, shortIDs: append([]string(nil), shortIDs...)})
+	return nil
+}
+
+func (f *fakeDeleter) waitCalls(t *testing.T, n int) []deleteCall {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		f.lock.Lock()
+		calls := append([]deleteCall(nil), f.calls...)
+		f.lock.Unlock()
+		if len(calls) >= n {
+			return calls
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d SetDeletedBatch calls", n)
+	return nil
+}
+
+func TestDeleterPoolBatchSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := &fakeDeleter{}
+	p := NewWithOptions(ctx, repo, Options{Workers: 1, BatchSize: 3, FlushInterval: time.Hour})
+
+	// пакет пользователя отправляется одним вызовом при достижении BatchSize
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "1"}
+	p.Input <- ToDeleteItem{UserID: "b", ShortID: "2"}
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "3"}
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "4"}
+
+	calls := repo.waitCalls(t, 1)
+	if len(calls) != 1 || calls[0].userID != "a" || len(calls[0].shortIDs) != 3 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestDeleterPoolFlushInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := &fakeDeleter{}
+	p := NewWithOptions(ctx, repo, Options{Workers: 1, BatchSize: 100, FlushInterval: 50 * time.Millisecond})
+
+	// неполные пакеты отправляются по таймеру, по одному вызову на пользователя
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "1"}
+	p.Input <- ToDeleteItem{UserID: "b", ShortID: "2"}
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "3"}
+
+	calls := repo.waitCalls(t, 2)
+	byUser := make(map[string]int)
+	for _, c := range calls {
+		byUser[c.userID] += len(c.shortIDs)
+	}
+	if len(calls) != 2 || byUser["a"] != 2 || byUser["b"] != 1 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+var errPermanent = errors.New("permanent")
+
+func testRetryOptions() Options {
+	return Options{
+		Workers:       1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		MaxAttempts:   3,
+		RetryDelay:    time.Millisecond,
+		IsTransient:   func(err error) bool { return !errors.Is(err, errPermanent) },
+	}
+}
+
+func TestDeleterPoolRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// временные ошибки повторяются
+	repo := &fakeDeleter{failures: []error{errors.New("conn reset"), errors.New("conn reset")}}
+	p := NewWithOptions(ctx, repo, testRetryOptions())
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "1"}
+	repo.waitCalls(t, 1)
+	if repo.attempts != 3 || len(p.DeadLetters()) != 0 {
+		t.Fatalf("attempts %d, dead letters %v", repo.attempts, p.DeadLetters())
+	}
+
+	// постоянная ошибка и исчерпание попыток - элементы в списке неудаленных, пул продолжает работу
+	repo = &fakeDeleter{failures: []error{errPermanent, errors.New("timeout"), errors.New("timeout"), errors.New("timeout")}}
+	p = NewWithOptions(ctx, repo, testRetryOptions())
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "1"}
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "2"}
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "3"}
+	calls := repo.waitCalls(t, 1)
+	dead := p.DeadLetters()
+	if len(dead) != 2 || dead[0].Item.ShortID != "1" || dead[1].Item.ShortID != "2" || calls[0].shortIDs[0] != "3" {
+		t.Fatalf("dead letters %v, calls %v", dead, calls)
+	}
+}
+
+func TestDeleterPoolSupervisor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := &fakeDeleter{failures: []error{errPanic}}
+	p := NewWithOptions(ctx, repo, testRetryOptions())
+
+	// обработчик перезапускается после паники
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "1"}
+	p.Input <- ToDeleteItem{UserID: "a", ShortID: "2"}
+	calls := repo.waitCalls(t, 1)
+	if calls[0].shortIDs[0] != "2" || len(p.DeadLetters()) != 1 {
+		t.Fatalf("calls %v, dead letters %v", calls, p.DeadLetters())
+	}
+
+	cancel()
+	p.Close()
+}
+
+// fakeJournal постоянная очередь в памяти: initial - элементы, оставшиеся от предыдущего запуска
+type fakeJournal struct {
+	lock     sync.Mutex
+	initial  []ToDeleteItem
+	enqueued []ToDeleteItem
+	acked    []ToDeleteItem
+}
+
+func (f *fakeJournal) EnqueueDeletes(_ context.Context, items []ToDeleteItem) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.enqueued = append(f.enqueued, items...)
+	return nil
+}
+
+func (f *fakeJournal) PendingDeletes(_ context.Context) ([]ToDeleteItem, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]ToDeleteItem(nil), f.initial...), nil
+}
+
+func (f *fakeJournal) AckDeletes(_ context.Context, userID string, shortIDs []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, shortID := range shortIDs {
+		f.acked = append(f.acked, ToDeleteItem{UserID: userID, ShortID: shortID})
+	}
+	return nil
+}
+
+func TestDeleterPoolJournal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := &fakeDeleter{}
+	journal := &fakeJournal{initial: []ToDeleteItem{{UserID: "a", ShortID: "1"}}}
+	opts := Options{Workers: 1, BatchSize: 1, FlushInterval: time.Hour, Journal: journal}
+	p := NewWithOptions(ctx, repo, opts)
+
+	// неподтвержденный элемент удаляется после запуска, новый - записывается в очередь до удаления
+	repo.waitCalls(t, 1)
+	if _, err := p.Submit(ctx, "a", []string{"2"}); err != nil {
+		t.Fatal(err)
+	}
+	calls := repo.waitCalls(t, 2)
+	if calls[0].shortIDs[0] != "1" || calls[1].shortIDs[0] != "2" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+
+	// оба элемента подтверждены после удаления
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		journal.lock.Lock()
+		enqueued, acked := len(journal.enqueued), len(journal.acked)
+		journal.lock.Unlock()
+		if acked == 2 && enqueued == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("enqueued %d, acked %d", enqueued, acked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// slowJournal запись в очередь элементов пользователя "slow" ждет release, пользователя "fail" - завершается ошибкой
+type slowJournal struct {
+	fakeJournal
+	entered chan struct{}
+	release chan struct{}
+}
+
+var errJournal = errors.New("journal failure")
+
+func (f *slowJournal) EnqueueDeletes(ctx context.Context, items []ToDeleteItem) error {
+	switch items[0].UserID {
+	case "slow":
+		close(f.entered)
+		<-f.release
+	case "fail":
+		return errJournal
+	}
+	return f.fakeJournal.EnqueueDeletes(ctx, items)
+}
+
+func TestDeleterPoolSubmitReserve(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	journal := &slowJournal{entered: make(chan struct{}), release: make(chan struct{})}
+	p := NewWithOptions(ctx, blockingDeleter{}, Options{Workers: 1, BufferSize: 2, BatchSize: 1, Journal: journal})
+
+	// обработчик занят первым элементом, Input пуст
+	if _, err := p.Submit(ctx, "a", []string{"0"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.Input) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("worker has not taken the item")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// ошибка записи в очередь снимает резерв
+	if _, err := p.Submit(ctx, "fail", []string{"1"}); !errors.Is(err, errJournal) {
+		t.Fatalf("expected journal error, got %v", err)
+	}
+
+	// медленная запись в очередь не задерживает другие запросы, но ее место зарезервировано
+	slowErr := make(chan error, 1)
+	go func() {
+		_, err := p.Submit(ctx, "slow", []string{"2"})
+		slowErr <- err
+	}()
+	<-journal.entered
+	if _, err := p.Submit(ctx, "a", []string{"3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Submit(ctx, "a", []string{"4"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	close(journal.release)
+	if err := <-slowErr; err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Input) != 2 {
+		t.Fatalf("input: got %d items, want 2", len(p.Input))
+	}
+}
+
+// blockingDeleter удаление, которое завершается только отменой контекста
+type blockingDeleter struct{}
+
+func (blockingDeleter) SetDeletedBatch(ctx context.Context, _ string, _ []string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// testSubmit отправка трех элементов двух пользователей
+func testSubmit(t *testing.T, p *DeleterPoolT) {
+	if _, err := p.Submit(context.Background(), "a", []string{"1", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Submit(context.Background(), "b", []string{"3"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleterPoolShutdown(t *testing.T) {
+	// оставшиеся пакеты удаляются при остановке, новые элементы не принимаются
+	repo := &fakeDeleter{}
+	p := NewWithOptions(context.Background(), repo, Options{Workers: 2, BatchSize: 100, FlushInterval: time.Hour})
+	testSubmit(t, p)
+	stats := p.Shutdown(context.Background())
+	if stats != (DrainStats{Flushed: 3}) || len(repo.calls) != 2 {
+		t.Fatalf("stats %+v, calls %v", stats, repo.calls)
+	}
+	if _, err := p.Submit(context.Background(), "a", []string{"4"}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("submit after shutdown: %v", err)
+	}
+
+	// по истечении срока остановки элементы бросаются без подтверждения в очереди
+	journal := &fakeJournal{}
+	p = NewWithOptions(context.Background(), blockingDeleter{}, Options{Workers: 1, BatchSize: 1, Journal: journal})
+	testSubmit(t, p)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stats = p.Shutdown(ctx)
+	if stats != (DrainStats{Abandoned: 3}) || len(journal.acked) != 0 || len(p.DeadLetters()) != 0 {
+		t.Fatalf("stats %+v, acked %v, dead letters %v", stats, journal.acked, p.DeadLetters())
+	}
+}
+
+func TestDeleterPoolJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// заполненная очередь отклоняет запрос без ожидания
+	p := NewWithOptions(ctx, blockingDeleter{}, Options{Workers: 1, BufferSize: 2, BatchSize: 100, FlushInterval: time.Hour})
+	if _, err := p.Submit(ctx, "a", []string{"1", "2", "3"}); !errors.Is(err, ErrTooManyItems) {
+		t.Fatalf("expected ErrTooManyItems, got %v", err)
+	}
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = p.Submit(ctx, "a", []string{strconv.Itoa(i)})
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	// состояние элементов задания доступно только владельцу
+	repo := &fakeDeleter{failures: []error{errPermanent}}
+	opts := testRetryOptions()
+	p = NewWithOptions(ctx, repo, opts)
+	failedJob, err := p.Submit(ctx, "a", []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneJob, err := p.Submit(ctx, "a", []string{"2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.waitCalls(t, 1)
+	p.Close()
+
+	job, ok := p.Job("a", failedJob)
+	if !ok || job.Count(JobFailed) != 1 || job.Items[0] != (JobItem{ShortID: "1", Status: JobFailed}) {
+		t.Fatalf("failed job %+v, %v", job, ok)
+	}
+	job, ok = p.Job("a", doneJob)
+	if !ok || job.Count(JobDone) != 1 || job.Count(JobQueued) != 0 {
+		t.Fatalf("done job %+v, %v", job, ok)
+	}
+	if _, ok = p.Job("b", doneJob); ok {
+		t.Fatal("job of another user is visible")
+	}
+}
@@ -0,0 +1,98 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// Состояние элемента задания
+const (
+	JobQueued = "queued"
+	JobDone   = "done"
+	JobFailed = "failed"
+)
+
+// Job задание Submit: состояние каждого короткого ID в порядке запроса
+type Job struct {
+	ID      string
+	UserID  string
+	Created time.Time
+	Items   []JobItem
+}
+
+type JobItem struct {
+	ShortID string
+	Status  string
+}
+
+// Count количество элементов задания в состоянии status
+func (j Job) Count(status string) int {
+	n := 0
+	for _, item := range j.Items {
+		if item.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// jobsT история последних заданий в оперативной памяти. После перезапуска задания не сохраняются:
+// элементы из постоянной очереди удаляются, но уже без задания
+type jobsT struct {
+	lock  sync.Mutex
+	jobs  map[string]*Job
+	order []string // ID заданий от старых к новым для вытеснения
+	size  int
+}
+
+func newJobs(size int) jobsT {
+	return jobsT{jobs: make(map[string]*Job), size: size}
+}
+
+func (j *jobsT) add(jobID string, userID string, shortIDs []string) {
+	job := &Job{ID: jobID, UserID: userID, Created: time.Now(), Items: make([]JobItem, len(shortIDs))}
+	for i, shortID := range shortIDs {
+		job.Items[i] = JobItem{ShortID: shortID, Status: JobQueued}
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.jobs[jobID] = job
+	j.order = append(j.order, jobID)
+	if extra := len(j.order) - j.size; extra > 0 {
+		for _, id := range j.order[:extra] {
+			delete(j.jobs, id)
+		}
+		j.order = append(j.order[:0], j.order[extra:]...)
+	}
+}
+
+// finish отметка обработанных элементов заданий
+func (j *jobsT) finish(items []ToDeleteItem, status string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for _, item := range items {
+		job, ok := j.jobs[item.JobID]
+		if !ok {
+			continue
+		}
+		for i := range job.Items {
+			if job.Items[i].ShortID == item.ShortID && job.Items[i].Status == JobQueued {
+				job.Items[i].Status = status
+				break
+			}
+		}
+	}
+}
+
+func (j *jobsT) get(userID string, jobID string) (Job, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	job, ok := j.jobs[jobID]
+	if !ok || job.UserID != userID {
+		return Job{}, false
+	}
+	snapshot := *job
+	snapshot.Items = append([]JobItem(nil), job.Items...)
+	return snapshot, true
+}
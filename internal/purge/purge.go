@@ -0,0 +1,142 @@
+package purge
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Purger хранилище, в котором удаленные записи удаляются окончательно пакетами
+type Purger interface {
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+}
+
+// Options параметры окончательного удаления. Нулевые значения заменяются значениями по умолчанию
+type Options struct {
+	Interval   time.Duration // период запуска
+	Retention  time.Duration // срок хранения удаленных записей
+	BatchSize  int           // записей в одном пакете
+	BatchPause time.Duration // пауза между пакетами, чтобы не мешать остальным запросам
+}
+
+func (o *Options) setDefaults() {
+	if o.Interval <= 0 {
+		o.Interval = time.Hour
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+	if o.BatchPause <= 0 {
+		o.BatchPause = 100 * time.Millisecond
+	}
+}
+
+// Stats счетчики окончательного удаления с момента запуска
+type Stats struct {
+	Runs         int64         // завершенных запусков
+	Purged       int64         // удалено записей
+	Errors       int64         // запусков, прерванных ошибкой хранилища
+	LastRun      time.Time     // начало последнего запуска
+	LastDuration time.Duration // длительность последнего запуска
+	LastPurged   int64         // удалено записей за последний запуск
+}
+
+// T периодическое окончательное удаление записей, удаленных раньше срока хранения
+type T struct {
+	repo   Purger
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	statsLock sync.Mutex
+	stats     Stats
+}
+
+// New создание и запуск периодического удаления
+func New(repo Purger, opts Options) *T {
+	opts.setDefaults()
+	p := &T{repo: repo, opts: opts}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+func (p *T) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = p.Run(p.ctx)
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Run один запуск: удаление пакетами, пока находятся записи старше срока хранения
+func (p *T) Run(ctx context.Context) (int64, error) {
+	start := time.Now()
+	deletedBefore := start.Add(-p.opts.Retention)
+	var purged int64
+	var err error
+	for batch := 1; ; batch++ {
+		var n int
+		n, err = p.repo.PurgeDeleted(ctx, deletedBefore, p.opts.BatchSize)
+		purged += int64(n)
+		if err != nil || n < p.opts.BatchSize {
+			break
+		}
+		log.Printf("purge: batch %d, %d rows purged so far\n", batch, purged)
+		if !p.sleep(ctx, p.opts.BatchPause) {
+			err = ctx.Err()
+			break
+		}
+	}
+	duration := time.Since(start)
+
+	p.statsLock.Lock()
+	p.stats.Runs++
+	p.stats.Purged += purged
+	if err != nil {
+		p.stats.Errors++
+	}
+	p.stats.LastRun, p.stats.LastDuration, p.stats.LastPurged = start, duration, purged
+	p.statsLock.Unlock()
+
+	if err != nil {
+		log.Printf("purge: %d rows deleted before %v purged in %v, stopped: %v\n", purged, deletedBefore.Format(time.RFC3339), duration, err)
+	} else {
+		log.Printf("purge: %d rows deleted before %v purged in %v\n", purged, deletedBefore.Format(time.RFC3339), duration)
+	}
+	return purged, err
+}
+
+// Stats копия счетчиков
+func (p *T) Stats() Stats {
+	p.statsLock.Lock()
+	defer p.statsLock.Unlock()
+	return p.stats
+}
+
+func (p *T) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Close остановка с ожиданием текущего запуска
+func (p *T) Close() {
+	p.cancel()
+	p.wg.Wait()
+	log.Println("purge has stopped")
+}
@@ -0,0 +1,66 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePurger хранилище с count записями старше срока хранения
+type fakePurger struct {
+	lock  sync.Mutex
+	count int
+	calls int
+	err   error
+}
+
+func (f *fakePurger) PurgeDeleted(_ context.Context, _ time.Time, limit int) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	if f.err != nil {
+		return 0, f.err
+	}
+	n := limit
+	if f.count < n {
+		n = f.count
+	}
+	f.count -= n
+	return n, nil
+}
+
+func TestRun(t *testing.T) {
+	repo := &fakePurger{count: 25}
+	p := New(repo, Options{Interval: time.Hour, BatchSize: 10, BatchPause: time.Millisecond})
+	defer p.Close()
+
+	// удаление пакетами до неполного пакета
+	purged, err := p.Run(context.Background())
+	if err != nil || purged != 25 || repo.calls != 3 {
+		t.Fatalf("purged %d, calls %d, err %v", purged, repo.calls, err)
+	}
+
+	repo.err = errors.New("connection refused")
+	if _, err = p.Run(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	stats := p.Stats()
+	if stats.Runs != 2 || stats.Purged != 25 || stats.Errors != 1 || stats.LastPurged != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	repo := &fakePurger{count: 5}
+	p := New(repo, Options{Interval: 10 * time.Millisecond, BatchSize: 10})
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats().Purged != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", p.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Close()
+}
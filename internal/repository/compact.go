@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// compactionT состояние сжатия файла хранилища
+type compactionT struct {
+	lock    sync.Mutex // не более одного сжатия одновременно
+	active  bool       // идет запись снимка; поля active и pending защищены storageLock
+	pending []record   // записи, добавленные в файл во время записи снимка
+}
+
+// track сохранение записей, добавленных во время сжатия. Вызывается под storageLock
+func (c *compactionT) track(records []record) {
+	if c.active {
+		c.pending = append(c.pending, records...)
+	}
+}
+
+// Compact сжатие файла хранилища: файл заменяется снимком текущего состояния без
+// устаревших записей. Снимок пишется во временный файл без блокировки хранилища,
+// записи, добавленные за это время, дописываются в конец снимка перед атомарной заменой файла
+func (r *Repository) Compact() error {
+	r.compaction.lock.Lock()
+	defer r.compaction.lock.Unlock()
+
+	// снимок состояния
+	r.storageLock.Lock()
+	if r.fileWriter.file == nil || r.records <= r.index.size {
+		r.storageLock.Unlock()
+		return nil
+	}
+	entities := r.index.all()
+	obsolete := r.records - len(entities)
+	r.compaction.active = true
+	r.compaction.pending = nil
+	r.storageLock.Unlock()
+
+	snapshot := make([]record, 0, len(entities))
+	for _, entity := range entities {
+		snapshot = append(snapshot, record{Op: opAdd, Entity: entity})
+	}
+
+	// запись снимка без блокировки хранилища
+	tmp, err := os.CreateTemp(filepath.Dir(r.fileName), filepath.Base(r.fileName)+".compact-*")
+	if err == nil {
+		err = writeSnapshot(tmp, snapshot)
+	}
+
+	r.storageLock.Lock()
+	defer r.storageLock.Unlock()
+	pending := r.compaction.pending
+	r.compaction.active = false
+	r.compaction.pending = nil
+	if tmp == nil {
+		return err
+	}
+
+	// дозапись изменений, сделанных во время записи снимка, и замена файла
+	if err == nil {
+		err = writeSnapshot(tmp, pending)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.fileName)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	// файл уже заменен: писать в старый нельзя, поэтому ошибка сохранения каталога
+	// только пишется в лог
+	if err = syncDir(filepath.Dir(r.fileName)); err != nil {
+		log.Printf("storage file %s: directory sync after compaction: %v\n", r.fileName, err)
+	}
+
+	// дальнейшая запись - в новый файл
+	_ = r.fileWriter.close()
+	err = r.fileWriter.new(r.fileName)
+	if err != nil {
+		return err
+	}
+	r.records = len(snapshot) + len(pending)
+	log.Printf("storage file %s compacted: %d records, %d obsolete records removed\n", r.fileName, r.records, obsolete)
+	return nil
+}
+
+func writeSnapshot(file *os.File, records []record) error {
+	w := bufio.NewWriter(file)
+	err := encodeRecords(w, records)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// syncDir сохранение на диск записи каталога после переименования файла
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// runCompaction периодическое сжатие файла до вызова Close
+func (r *Repository) runCompaction(interval time.Duration) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Compact(); err != nil {
+				log.Printf("storage compaction error: %v\n", err)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
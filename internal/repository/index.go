@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"sync"
+)
+
+const shardCount = 32
+
+// indexT индекс записей в оперативной памяти, разбитый на сегменты.
+// Изменения выполняются под storageLock хранилища (писатель всегда один), поэтому чтение
+// берет только блокировку своего сегмента на чтение и не конкурирует с записью в файл
+type indexT struct {
+	entities [shardCount]entityShardT
+	longURLs [shardCount]longURLShardT
+	users    [shardCount]userShardT
+	size     int // количество записей; изменяется и читается под storageLock
+}
+
+// entityShardT ID короткого URL -> запись
+type entityShardT struct {
+	sync.RWMutex
+	m map[string]db.Entity
+}
+
+// longURLShardT обратный индекс: пользователь и длинный URL -> ID короткого URL.
+// Длинный URL уникален в пределах пользователя
+type longURLShardT struct {
+	sync.RWMutex
+	m map[string]string
+}
+
+// userShardT индекс по пользователю: ID пользователя -> множество ID коротких URL
+type userShardT struct {
+	sync.RWMutex
+	m map[string]map[string]struct{}
+}
+
+func newIndex() *indexT {
+	idx := &indexT{}
+	for i := 0; i < shardCount; i++ {
+		idx.entities[i].m = make(map[string]db.Entity)
+		idx.longURLs[i].m = make(map[string]string)
+		idx.users[i].m = make(map[string]map[string]struct{})
+	}
+	return idx
+}
+
+func longURLKey(userID string, longURL string) string {
+	return userID + "\x00" + longURL
+}
+
+// shard номер сегмента для ключа (FNV-1a)
+func shard(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h % shardCount
+}
+
+func (idx *indexT) get(shortID string) (db.Entity, bool) {
+	s := &idx.entities[shard(shortID)]
+	s.RLock()
+	defer s.RUnlock()
+	entity, ok := s.m[shortID]
+	return entity, ok
+}
+
+func (idx *indexT) getByLongURL(userID string, longURL string) (db.Entity, bool) {
+	key := longURLKey(userID, longURL)
+	s := &idx.longURLs[shard(key)]
+	s.RLock()
+	shortID, ok := s.m[key]
+	s.RUnlock()
+	if !ok {
+		return db.Entity{}, false
+	}
+	return idx.get(shortID)
+}
+
+func (idx *indexT) getByUser(userID string) []db.Entity {
+	s := &idx.users[shard(userID)]
+	s.RLock()
+	shortIDs := make([]string, 0, len(s.m[userID]))
+	for shortID := range s.m[userID] {
+		shortIDs = append(shortIDs, shortID)
+	}
+	s.RUnlock()
+
+	selection := make([]db.Entity, 0, len(shortIDs))
+	for _, shortID := range shortIDs {
+		if entity, ok := idx.get(shortID); ok {
+			selection = append(selection, entity)
+		}
+	}
+	return selection
+}
+
+// put добавление или замена записи. Вызывается под storageLock
+func (idx *indexT) put(entity db.Entity) {
+	s := &idx.entities[shard(entity.ShortID)]
+	s.Lock()
+	old, exists := s.m[entity.ShortID]
+	s.m[entity.ShortID] = entity
+	s.Unlock()
+
+	switch {
+	case !exists:
+		idx.size++
+		idx.link(entity)
+	case old.LongURL != entity.LongURL || old.UserID != entity.UserID:
+		idx.unlink(old)
+		idx.link(entity)
+	}
+}
+
+// remove удаление записи. Вызывается под storageLock
+func (idx *indexT) remove(shortID string) {
+	s := &idx.entities[shard(shortID)]
+	s.Lock()
+	old, exists := s.m[shortID]
+	delete(s.m, shortID)
+	s.Unlock()
+
+	if exists {
+		idx.size--
+		idx.unlink(old)
+	}
+}
+
+// link добавление записи во вторичные индексы
+func (idx *indexT) link(entity db.Entity) {
+	key := longURLKey(entity.UserID, entity.LongURL)
+	ls := &idx.longURLs[shard(key)]
+	ls.Lock()
+	ls.m[key] = entity.ShortID
+	ls.Unlock()
+
+	us := &idx.users[shard(entity.UserID)]
+	us.Lock()
+	shortIDs, ok := us.m[entity.UserID]
+	if !ok {
+		shortIDs = make(map[string]struct{})
+		us.m[entity.UserID] = shortIDs
+	}
+	shortIDs[entity.ShortID] = struct{}{}
+	us.Unlock()
+}
+
+// unlink удаление записи из вторичных индексов
+func (idx *indexT) unlink(entity db.Entity) {
+	key := longURLKey(entity.UserID, entity.LongURL)
+	ls := &idx.longURLs[shard(key)]
+	ls.Lock()
+	if ls.m[key] == entity.ShortID {
+		delete(ls.m, key)
+	}
+	ls.Unlock()
+
+	us := &idx.users[shard(entity.UserID)]
+	us.Lock()
+	delete(us.m[entity.UserID], entity.ShortID)
+	if len(us.m[entity.UserID]) == 0 {
+		delete(us.m, entity.UserID)
+	}
+	us.Unlock()
+}
+
+// all копия всех записей для снимка хранилища. Вызывается под storageLock
+func (idx *indexT) all() []db.Entity {
+	entities := make([]db.Entity, 0, idx.size)
+	for i := range idx.entities {
+		s := &idx.entities[i]
+		s.RLock()
+		for _, entity := range s.m {
+			entities = append(entities, entity)
+		}
+		s.RUnlock()
+	}
+	return entities
+}
+
+// find не более limit записей, удовлетворяющих match. Вызывается под storageLock
+func (idx *indexT) find(limit int, match func(entity db.Entity) bool) []db.Entity {
+	found := make([]db.Entity, 0, limit)
+	for i := range idx.entities {
+		s := &idx.entities[i]
+		s.RLock()
+		for _, entity := range s.m {
+			if len(found) == limit {
+				break
+			}
+			if match(entity) {
+				found = append(found, entity)
+			}
+		}
+		s.RUnlock()
+		if len(found) == limit {
+			break
+		}
+	}
+	return found
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Сравнение индекса с сегментами и прежней реализации (map под одним sync.Mutex)
+// при параллельной нагрузке:
+//   go test -bench=Index -benchmem ./internal/repository/
+
+type benchIndex interface {
+	get(shortID string) (db.Entity, bool)
+	getByUser(userID string) []db.Entity
+	put(entity db.Entity)
+}
+
+// mutexIndex прежняя реализация из Repository (SelectByShortID, SelectByUser и AddEntity без записи в файл):
+// все операции под одной блокировкой, выборка пользователя - полный перебор
+type mutexIndex struct {
+	lock    sync.Mutex
+	storage map[string]db.Entity
+}
+
+func (m *mutexIndex) get(shortID string) (db.Entity, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entity, ok := m.storage[shortID]
+	return entity, ok
+}
+
+func (m *mutexIndex) getByUser(userID string) []db.Entity {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	selection := make([]db.Entity, 0, 10)
+	for _, entity := range m.storage {
+		if userID == entity.UserID {
+			selection = append(selection, entity)
+		}
+	}
+	return selection
+}
+
+func (m *mutexIndex) put(entity db.Entity) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.storage[entity.ShortID] = entity
+}
+
+// shardedIndex индекс с сегментами; запись сериализуется так же, как storageLock в Repository
+type shardedIndex struct {
+	lock sync.Mutex
+	*indexT
+}
+
+func (s *shardedIndex) put(entity db.Entity) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.indexT.put(entity)
+}
+
+const (
+	benchEntities = 100000
+	benchUsers    = 1000
+)
+
+func benchEntity(i int) db.Entity {
+	return db.Entity{
+		UserID:  "user" + strconv.Itoa(i%benchUsers),
+		ShortID: "short" + strconv.Itoa(i),
+		LongURL: "https://yandex.ru/" + strconv.Itoa(i),
+	}
+}
+
+func benchIndexes() map[string]func() benchIndex {
+	return map[string]func() benchIndex{
+		"mutex":   func() benchIndex { return &mutexIndex{storage: make(map[string]db.Entity)} },
+		"sharded": func() benchIndex { return &shardedIndex{indexT: newIndex()} },
+	}
+}
+
+func fillIndex(idx benchIndex) {
+	for i := 0; i < benchEntities; i++ {
+		idx.put(benchEntity(i))
+	}
+}
+
+// BenchmarkIndexGet переходы по коротким ссылкам с 1% записей
+func BenchmarkIndexGet(b *testing.B) {
+	for name, newBenchIndex := range benchIndexes() {
+		b.Run(name, func(b *testing.B) {
+			idx := newBenchIndex()
+			fillIndex(idx)
+			var counter int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(atomic.AddInt64(&counter, 1))
+					if i%100 == 0 {
+						idx.put(benchEntity(benchEntities + i))
+					} else {
+						idx.get("short" + strconv.Itoa(i%benchEntities))
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkIndexGetByUser история пользователя с 1% записей
+func BenchmarkIndexGetByUser(b *testing.B) {
+	for name, newBenchIndex := range benchIndexes() {
+		b.Run(name, func(b *testing.B) {
+			idx := newBenchIndex()
+			fillIndex(idx)
+			var counter int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(atomic.AddInt64(&counter, 1))
+					if i%100 == 0 {
+						idx.put(benchEntity(benchEntities + i))
+					} else {
+						idx.getByUser("user" + strconv.Itoa(i%benchUsers))
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	idx := newIndex()
+	e := benchEntity(1)
+	idx.put(e)
+	got, ok := idx.getByLongURL(e.UserID, e.LongURL)
+	require.True(t, ok)
+	require.Equal(t, e, got)
+
+	// замена записи с другим владельцем и URL обновляет вторичные индексы
+	moved := e
+	moved.UserID, moved.LongURL = "other", "https://habr.com/"
+	idx.put(moved)
+	_, ok = idx.getByLongURL(e.UserID, e.LongURL)
+	require.False(t, ok, "stale long URL index")
+	require.Len(t, idx.getByUser(e.UserID), 0)
+	require.Len(t, idx.getByUser(moved.UserID), 1)
+	require.Equal(t, 1, idx.size)
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
+	"log"
+	"os"
+	"sync"
+)
+
+// Записи файла очереди удаления: добавление элемента и подтверждение его обработки
+const (
+	opEnqueue = "enqueue"
+	opAck     = "ack"
+)
+
+// journalSuffix суффикс имени файла очереди удаления рядом с файлом хранилища
+const journalSuffix = ".deletes"
+
+// journalT постоянная очередь удаления файлового хранилища. Файл содержит записи
+// добавления и подтверждения; когда неподтвержденных элементов не остается, файл очищается.
+// Записи сбрасываются на диск сразу, независимо от SyncPolicy хранилища
+type journalT struct {
+	lock    sync.Mutex
+	file    *os.File
+	pending map[pool.ToDeleteItem]int // количество неподтвержденных добавлений элемента
+	order   []pool.ToDeleteItem       // элементы, неподтвержденные при открытии, в порядке добавления
+}
+
+func openJournal(fileName string) (*journalT, error) {
+	j := &journalT{pending: make(map[pool.ToDeleteItem]int)}
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return nil, err
+	}
+	j.file = file
+
+	var order []pool.ToDeleteItem
+	validSize, discarded, err := readRecords(file, func(rec record) {
+		item := pool.ToDeleteItem{UserID: rec.UserID, ShortID: rec.ShortID}
+		switch rec.Op {
+		case opEnqueue:
+			if j.pending[item] == 0 {
+				order = append(order, item)
+			}
+			j.pending[item]++
+		case opAck:
+			delete(j.pending, item)
+		}
+	})
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if discarded > 0 {
+		log.Printf("delete queue %s: %d corrupted records discarded\n", fileName, discarded)
+	}
+	for _, item := range order {
+		if j.pending[item] > 0 {
+			j.order = append(j.order, item)
+		}
+	}
+
+	// без неподтвержденных элементов файл очищается, иначе обрезается оборванный при сбое хвост,
+	// о записи которого клиенту не ответили
+	if len(j.order) == 0 {
+		validSize = 0
+	}
+	if err = file.Truncate(validSize); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// write запись в файл одним вызовом Write со сбросом на диск
+func (j *journalT) write(records []record) error {
+	buf := bytes.Buffer{}
+	if err := encodeRecords(&buf, records); err != nil {
+		return err
+	}
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *journalT) enqueue(items []pool.ToDeleteItem) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	records := make([]record, len(items))
+	for i, item := range items {
+		records[i] = record{Op: opEnqueue, Entity: db.Entity{UserID: item.UserID, ShortID: item.ShortID}}
+	}
+	if err := j.write(records); err != nil {
+		return err
+	}
+	for _, item := range items {
+		j.pending[pool.ToDeleteItem{UserID: item.UserID, ShortID: item.ShortID}]++
+	}
+	return nil
+}
+
+// takePending неподтвержденные при открытии элементы; возвращаются один раз
+func (j *journalT) takePending() []pool.ToDeleteItem {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	items := j.order
+	j.order = nil
+	return items
+}
+
+func (j *journalT) ack(userID string, shortIDs []string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	records := make([]record, 0, len(shortIDs))
+	for _, shortID := range shortIDs {
+		item := pool.ToDeleteItem{UserID: userID, ShortID: shortID}
+		if j.pending[item] == 0 {
+			continue
+		}
+		delete(j.pending, item)
+		records = append(records, record{Op: opAck, Entity: db.Entity{UserID: userID, ShortID: shortID}})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if len(j.pending) == 0 {
+		// все элементы обработаны - история файлу не нужна
+		return j.file.Truncate(0)
+	}
+	return j.write(records)
+}
+
+func (j *journalT) close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.file.Close()
+}
+
+// EnqueueDeletes запись элементов в постоянную очередь удаления до ответа клиенту.
+// Для хранилища в оперативной памяти очередь не ведется
+func (r *Repository) EnqueueDeletes(_ context.Context, items []pool.ToDeleteItem) error {
+	if r.deleteJournal == nil {
+		return nil
+	}
+	return r.deleteJournal.enqueue(items)
+}
+
+// PendingDeletes элементы, не подтвержденные до перезапуска
+func (r *Repository) PendingDeletes(_ context.Context) ([]pool.ToDeleteItem, error) {
+	if r.deleteJournal == nil {
+		return nil, nil
+	}
+	return r.deleteJournal.takePending(), nil
+}
+
+// AckDeletes подтверждение обработки элементов пользователя
+func (r *Repository) AckDeletes(_ context.Context, userID string, shortIDs []string) error {
+	if r.deleteJournal == nil {
+		return nil
+	}
+	return r.deleteJournal.ack(userID, shortIDs)
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"hash/crc32"
+	"io"
+	"strconv"
+)
+
+// record строка файла хранилища. Старые файлы содержат только db.Entity без поля op,
+// такие строки читаются как добавление записи
+type record struct {
+	Op string `json:"op,omitempty"`
+	db.Entity
+	// пакет записей, добавленных одним вызовом: Batch - размер пакета, Part - номер записи с 1.
+	// При чтении пакет применяется только целиком
+	Batch int `json:"batch,omitempty"`
+	Part  int `json:"part,omitempty"`
+}
+
+const (
+	opAdd    = ""
+	opUpdate = "update"
+	opDelete = "delete" // окончательное удаление записи; из Entity используется только ShortID
+)
+
+// Формат строки файла: "<длина JSON, 8 hex> <CRC-32C JSON, 8 hex> <JSON>\n".
+// Строки старого формата (только JSON) читаются без проверки контрольной суммы
+const recordHeaderLen = 18
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errBadRecord = errors.New("corrupted storage record")
+
+func encodeRecords(w io.Writer, records []record) error {
+	for i := range records {
+		payload, err := json.Marshal(&records[i])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%08x %08x %s\n", len(payload), crc32.Checksum(payload, crcTable), payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeBatch запись пакета с отметками Batch и Part, чтобы оборванный пакет отбрасывался при чтении
+func encodeBatch(w io.Writer, records []record) error {
+	if len(records) < 2 {
+		return encodeRecords(w, records)
+	}
+	batch := make([]record, len(records))
+	for i, rec := range records {
+		rec.Batch, rec.Part = len(records), i+1
+		batch[i] = rec
+	}
+	return encodeRecords(w, batch)
+}
+
+// decodeRecord разбор строки файла без завершающего '\n'
+func decodeRecord(line []byte) (record, error) {
+	rec := record{}
+	payload := line
+	if len(line) > 0 && line[0] != '{' {
+		if len(line) < recordHeaderLen || line[8] != ' ' || line[17] != ' ' {
+			return rec, errBadRecord
+		}
+		size, err1 := strconv.ParseUint(string(line[:8]), 16, 32)
+		sum, err2 := strconv.ParseUint(string(line[9:17]), 16, 32)
+		payload = line[recordHeaderLen:]
+		if err1 != nil || err2 != nil || int(size) != len(payload) || uint32(sum) != crc32.Checksum(payload, crcTable) {
+			return rec, errBadRecord
+		}
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, errBadRecord
+	}
+	return rec, nil
+}
+
+// readRecords чтение записей файла. Поврежденные записи и неполные пакеты пропускаются
+// и подсчитываются в discarded. validSize - размер файла до конца последней целой записи
+// или пакета: все, что дальше, - оборванный хвост
+func readRecords(r io.Reader, fn func(rec record)) (validSize int64, discarded int, err error) {
+	reader := bufio.NewReader(r)
+	var offset int64
+	var batch []record // начатый и еще не дочитанный пакет
+	for {
+		line, err := reader.ReadBytes('\n')
+		offset += int64(len(line))
+		if err == io.EOF {
+			if len(line) > 0 {
+				// запись оборвана при сбое во время записи
+				discarded++
+			}
+			return validSize, discarded + len(batch), nil
+		} else if err != nil {
+			return validSize, discarded, err
+		}
+
+		rec, errDecode := decodeRecord(line[:len(line)-1])
+		if errDecode != nil {
+			discarded++
+			continue
+		}
+		if len(batch) > 0 && rec.Part != len(batch)+1 {
+			// пакет прерван другой записью
+			discarded += len(batch)
+			batch = nil
+		}
+		if rec.Batch == 0 {
+			fn(rec)
+			validSize = offset
+			continue
+		}
+		if rec.Part != len(batch)+1 {
+			// запись из середины пакета без его начала
+			discarded++
+			continue
+		}
+		batch = append(batch, rec)
+		if len(batch) == rec.Batch {
+			for _, b := range batch {
+				fn(b)
+			}
+			batch = nil
+			validSize = offset
+		}
+	}
+}
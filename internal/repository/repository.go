@@ -1,33 +1,79 @@
 package repository
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
 	"github.com/antonevtu/go-musthave-shortener-tpl/internal/pool"
-	"io"
+	"log"
 	"os"
 	"sync"
+	"time"
 )
 
 type Repository struct {
-	storage     storageT
-	storageLock sync.Mutex
+	index       *indexT
+	storageLock sync.Mutex // сериализует изменения хранилища и запись в файл
 	fileWriter  fileWriterT
+	fileName    string
+	records     int // количество записей в файле, включая устаревшие
+	compaction  compactionT
+	// постоянная очередь удаления рядом с файлом хранилища; nil для хранилища в оперативной памяти
+	deleteJournal *journalT
+	done          chan struct{}
+	wg            sync.WaitGroup
 }
 
-type storageT map[string]db.Entity
+// Options параметры файлового хранилища
+type Options struct {
+	CompactInterval time.Duration // период фонового сжатия файла; 0 - сжатие только по вызову Compact
+	SyncPolicy      SyncPolicy    // по умолчанию SyncNever
+	SyncInterval    time.Duration // период сброса на диск для SyncInterval
+}
 
 type fileWriterT struct {
-	file    *os.File
-	encoder *json.Encoder
+	file   *os.File
+	policy SyncPolicy
+	dirty  bool // есть записи, не сброшенные на диск
 }
 
+// SyncPolicy режим сброса записей файла хранилища на диск (fsync)
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // после каждой записи
+	SyncInterval SyncPolicy = "interval" // периодически, с периодом Options.SyncInterval
+	SyncNever    SyncPolicy = "never"    // на усмотрение операционной системы
+)
+
+// New создает хранилище в оперативной памяти с копией в файле fileName.
+// При пустом fileName данные хранятся только в оперативной памяти
 func New(fileName string) (*Repository, error) {
+	return NewWithOptions(fileName, Options{})
+}
+
+func NewWithOptions(fileName string, opts Options) (*Repository, error) {
 	repository := Repository{
-		storage:    make(storageT, 100),
-		fileWriter: fileWriterT{},
+		index:      newIndex(),
+		fileWriter: fileWriterT{policy: opts.SyncPolicy},
+		fileName:   fileName,
+		done:       make(chan struct{}),
+	}
+	if fileName == "" {
+		return &repository, nil
+	}
+
+	switch opts.SyncPolicy {
+	case "":
+		repository.fileWriter.policy = SyncNever
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		if opts.SyncInterval <= 0 {
+			return &repository, fmt.Errorf("invalid sync interval %v", opts.SyncInterval)
+		}
+	default:
+		return &repository, fmt.Errorf("unknown sync policy %q", opts.SyncPolicy)
 	}
 
 	err := repository.restoreFromFile(fileName)
@@ -39,6 +85,25 @@ func New(fileName string) (*Repository, error) {
 	if err != nil {
 		return &repository, err
 	}
+
+	err = repository.stampDeletedAt()
+	if err != nil {
+		return &repository, err
+	}
+
+	repository.deleteJournal, err = openJournal(fileName + journalSuffix)
+	if err != nil {
+		return &repository, err
+	}
+
+	if opts.CompactInterval > 0 {
+		repository.wg.Add(1)
+		go repository.runCompaction(opts.CompactInterval)
+	}
+	if repository.fileWriter.policy == SyncInterval {
+		repository.wg.Add(1)
+		go repository.runSync(opts.SyncInterval)
+	}
 	return &repository, nil
 }
 
@@ -47,84 +112,346 @@ func (fw *fileWriterT) new(filename string) error {
 	if err != nil {
 		return err
 	}
-	*fw = fileWriterT{
-		file:    file,
-		encoder: json.NewEncoder(file),
+	fw.file = file
+	fw.dirty = false
+	return nil
+}
+
+// write Запись в файл одним вызовом Write, чтобы пакет записей не разрывался другими записями
+func (fw *fileWriterT) write(records ...record) error {
+	if fw.file == nil {
+		return nil
+	}
+	buf := bytes.Buffer{}
+	err := encodeBatch(&buf, records)
+	if err != nil {
+		return err
+	}
+	_, err = fw.file.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if fw.policy == SyncAlways {
+		return fw.file.Sync()
 	}
+	fw.dirty = true
 	return nil
 }
 
-// restoreFromFile Восстановление хранилища в оперативной памяти из текстового файла
+// sync сброс записей на диск, если были изменения
+func (fw *fileWriterT) sync() error {
+	if fw.file == nil || !fw.dirty {
+		return nil
+	}
+	fw.dirty = false
+	return fw.file.Sync()
+}
+
+func (fw *fileWriterT) ping() error {
+	if fw.file == nil {
+		return nil
+	}
+	_, err := fw.file.Stat()
+	return err
+}
+
+func (fw *fileWriterT) close() error {
+	if fw.file == nil {
+		return nil
+	}
+	if fw.policy != SyncNever {
+		_ = fw.sync()
+	}
+	return fw.file.Close()
+}
+
+// restoreFromFile Восстановление хранилища в оперативной памяти из текстового файла.
+// Оборванная при сбое запись в конце файла отбрасывается, файл обрезается до последней целой записи
 func (r *Repository) restoreFromFile(fileName string) error {
 	file, err := os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0777)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	decoder := json.NewDecoder(file)
-	entity := db.Entity{}
+
+	validSize, discarded, err := readRecords(file, func(rec record) {
+		r.apply(rec)
+		r.records++
+	})
+	if err != nil {
+		return err
+	}
+	if discarded > 0 {
+		log.Printf("storage file %s: %d corrupted records discarded\n", fileName, discarded)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > validSize {
+		log.Printf("storage file %s: truncated torn tail of %d bytes\n", fileName, info.Size()-validSize)
+		return os.Truncate(fileName, validSize)
+	}
+	return nil
+}
+
+// stampDeletedAt записям, удаленным до появления времени удаления в файле, присваивается
+// время загрузки, как при миграции базы данных: они восстанавливаются и окончательно удаляются по общим правилам
+func (r *Repository) stampDeletedAt() error {
+	unstamped := r.index.find(r.index.size, func(entity db.Entity) bool {
+		return entity.Deleted && entity.DeletedAt == nil
+	})
+	if len(unstamped) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	records := make([]record, 0, len(unstamped))
+	for _, entity := range unstamped {
+		entity.DeletedAt = &now
+		records = append(records, record{Op: opUpdate, Entity: entity})
+	}
+	return r.persist(records...)
+}
+
+// runSync периодический сброс записей на диск до вызова Close
+func (r *Repository) runSync(interval time.Duration) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
-		err = decoder.Decode(&entity)
-		if err == io.EOF {
-			return nil
-		} else if err != nil {
-			return err
+		select {
+		case <-ticker.C:
+			r.storageLock.Lock()
+			err := r.fileWriter.sync()
+			r.storageLock.Unlock()
+			if err != nil {
+				log.Printf("storage sync error: %v\n", err)
+			}
+		case <-r.done:
+			return
 		}
-		r.storage[entity.ShortID] = entity
+	}
+}
+
+// persist запись в файл и применение к хранилищу в оперативной памяти.
+// Вызывается под storageLock
+func (r *Repository) persist(records ...record) error {
+	err := r.fileWriter.write(records...)
+	if err != nil {
+		return err
+	}
+	r.compaction.track(records)
+	r.records += len(records)
+	for _, rec := range records {
+		r.apply(rec)
+	}
+	return nil
+}
+
+// apply применение записи файла к хранилищу в оперативной памяти
+func (r *Repository) apply(rec record) {
+	switch rec.Op {
+	case opAdd, opUpdate:
+		r.index.put(rec.Entity)
+	case opDelete:
+		r.index.remove(rec.ShortID)
 	}
 }
 
 func (r *Repository) AddEntity(_ context.Context, entity db.Entity) error {
 	r.storageLock.Lock()
 	defer r.storageLock.Unlock()
-	r.storage[entity.ShortID] = entity
-	err := r.fileWriter.encoder.Encode(&entity)
-	return err
+	if _, ok := r.index.getByLongURL(entity.UserID, entity.LongURL); ok {
+		return db.ErrUniqueViolation
+	}
+	if _, ok := r.index.get(entity.ShortID); ok {
+		return db.ErrShortIDTaken
+	}
+	return r.persist(record{Op: opAdd, Entity: entity})
 }
 
-func (r *Repository) SelectByLongURL(_ context.Context, id string) (db.Entity, error) {
-	return db.Entity{}, errors.New("method not supported")
+func (r *Repository) SelectByLongURL(_ context.Context, userID string, longURL string) (db.Entity, error) {
+	entity, ok := r.index.getByLongURL(userID, longURL)
+	if !ok {
+		return db.Entity{}, db.ErrNotFound
+	}
+	return entity, nil
 }
 
 func (r *Repository) SelectByShortID(_ context.Context, id string) (db.Entity, error) {
-	r.storageLock.Lock()
-	defer r.storageLock.Unlock()
-	entity, ok := r.storage[id]
+	entity, ok := r.index.get(id)
 	if ok {
 		return entity, nil
 	} else {
-		return db.Entity{}, errors.New("a non-existent ID was requested")
+		return db.Entity{}, db.ErrNotFound
 	}
 }
 
-func (r *Repository) SelectByUser(_ context.Context, userID string) ([]db.Entity, error) {
-	r.storageLock.Lock()
-	defer r.storageLock.Unlock()
-	selection := make([]db.Entity, 0, 10)
-	for _, entity := range r.storage {
-		if userID == entity.UserID {
+// SelectByShortIDs существующие записи с ID из shortIDs в порядке shortIDs
+func (r *Repository) SelectByShortIDs(_ context.Context, shortIDs []string) ([]db.Entity, error) {
+	selection := make([]db.Entity, 0, len(shortIDs))
+	for _, shortID := range shortIDs {
+		if entity, ok := r.index.get(shortID); ok {
 			selection = append(selection, entity)
 		}
 	}
 	return selection, nil
 }
 
+func (r *Repository) SelectByUser(_ context.Context, userID string) ([]db.Entity, error) {
+	return r.index.getByUser(userID), nil
+}
+
 func (r *Repository) Close() {
-	_ = r.fileWriter.file.Close()
+	close(r.done)
+	r.wg.Wait()
+
+	r.compaction.lock.Lock()
+	defer r.compaction.lock.Unlock()
+	r.storageLock.Lock()
+	defer r.storageLock.Unlock()
+	_ = r.fileWriter.close()
+	if r.deleteJournal != nil {
+		_ = r.deleteJournal.close()
+	}
 }
 
-func (r *Repository) AddEntityBatch(_ context.Context, _ string, _ db.BatchInput) error {
-	return errors.New("batches not supported")
+// AddEntityBatch добавление пакета записей одной записью в файл. Элементы с уже существующим
+// у пользователя длинным URL (в том числе повторяющиеся внутри пакета) не добавляются, а получают ID существующей записи
+func (r *Repository) AddEntityBatch(_ context.Context, userID string, input db.BatchInput) error {
+	r.storageLock.Lock()
+	defer r.storageLock.Unlock()
+
+	records := make([]record, 0, len(input))
+	batchURLs := make(map[string]string, len(input))
+	batchIDs := make(map[string]struct{}, len(input))
+	for i, v := range input {
+		if existing, ok := r.index.getByLongURL(userID, v.OriginalURL); ok {
+			input[i].ShortID = existing.ShortID
+			input[i].Status = db.BatchExists
+			continue
+		}
+		if shortID, ok := batchURLs[v.OriginalURL]; ok {
+			input[i].ShortID = shortID
+			input[i].Status = db.BatchExists
+			continue
+		}
+		// пакет с уже занятым ID не добавляется целиком, как и в db.T
+		if _, ok := r.index.get(v.ShortID); ok {
+			return db.ErrShortIDTaken
+		}
+		if _, ok := batchIDs[v.ShortID]; ok {
+			return db.ErrShortIDTaken
+		}
+		batchIDs[v.ShortID] = struct{}{}
+		batchURLs[v.OriginalURL] = v.ShortID
+		input[i].Status = db.BatchCreated
+		records = append(records, record{Op: opAdd, Entity: db.Entity{
+			Deleted: v.Deleted,
+			UserID:  userID,
+			ShortID: v.ShortID,
+			LongURL: v.OriginalURL,
+		}})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return r.persist(records...)
 }
 
 func (r *Repository) Ping(_ context.Context) error {
-	return errors.New("ping not supported")
+	r.storageLock.Lock()
+	defer r.storageLock.Unlock()
+	return r.fileWriter.ping()
 }
 
-func (r *Repository) SetDeletedBatch(ctx context.Context, userID string, shortIDs []string) error {
-	return errors.New("method not supported")
+// SetDeletedBatch пометка записей пользователя удаленными. Чужие и несуществующие
+// записи пропускаются, как и в db.T
+func (r *Repository) SetDeletedBatch(_ context.Context, userID string, shortIDs []string) error {
+	r.storageLock.Lock()
+	defer r.storageLock.Unlock()
+
+	now := time.Now().UTC()
+	records := make([]record, 0, len(shortIDs))
+	seen := make(map[string]struct{}, len(shortIDs))
+	for _, shortID := range shortIDs {
+		entity, ok := r.index.get(shortID)
+		if !ok || entity.UserID != userID || entity.Deleted {
+			continue
+		}
+		if _, ok = seen[shortID]; ok {
+			continue
+		}
+		seen[shortID] = struct{}{}
+		entity.Deleted = true
+		entity.DeletedAt = &now
+		records = append(records, record{Op: opUpdate, Entity: entity})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	return r.persist(records...)
 }
 
 func (r *Repository) SetDeleted(ctx context.Context, item pool.ToDeleteItem) error {
-	return errors.New("method not supported")
+	return r.SetDeletedBatch(ctx, item.UserID, []string{item.ShortID})
+}
+
+// RestoreBatch восстановление записей пользователя, удаленных не раньше deletedSince.
+// Возвращает ID восстановленных записей; чужие, неудаленные и несуществующие записи пропускаются
+func (r *Repository) RestoreBatch(_ context.Context, userID string, shortIDs []string, deletedSince time.Time) ([]string, error) {
+	r.storageLock.Lock()
+	defer r.storageLock.Unlock()
+
+	restored := make([]string, 0, len(shortIDs))
+	records := make([]record, 0, len(shortIDs))
+	seen := make(map[string]struct{}, len(shortIDs))
+	for _, shortID := range shortIDs {
+		entity, ok := r.index.get(shortID)
+		if !ok || entity.UserID != userID || !entity.Deleted {
+			continue
+		}
+		if entity.DeletedAt != nil && entity.DeletedAt.Before(deletedSince) {
+			continue
+		}
+		if _, ok = seen[shortID]; ok {
+			continue
+		}
+		seen[shortID] = struct{}{}
+		entity.Deleted = false
+		entity.DeletedAt = nil
+		records = append(records, record{Op: opUpdate, Entity: entity})
+		restored = append(restored, shortID)
+	}
+	if len(records) == 0 {
+		return restored, nil
+	}
+	if err := r.persist(records...); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// PurgeDeleted окончательное удаление не более limit записей, удаленных раньше deletedBefore.
+// Возвращает количество удаленных записей
+func (r *Repository) PurgeDeleted(_ context.Context, deletedBefore time.Time, limit int) (int, error) {
+	r.storageLock.Lock()
+	defer r.storageLock.Unlock()
+
+	expired := r.index.find(limit, func(entity db.Entity) bool {
+		return entity.Deleted && entity.DeletedAt != nil && entity.DeletedAt.Before(deletedBefore)
+	})
+	records := make([]record, 0, len(expired))
+	for _, entity := range expired {
+		records = append(records, record{Op: opDelete, Entity: db.Entity{ShortID: entity.ShortID}})
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	if err := r.persist(records...); err != nil {
+		return 0, err
+	}
+	return len(records), nil
 }
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"github.com/antonevtu/go-musthave-shortener-tpl/internal/db"
+	"io"
+	"os"
+)
+
+// SnapshotStats результат чтения файла хранилища
+type SnapshotStats struct {
+	Records   int // целых записей в файле, включая устаревшие
+	Discarded int // поврежденных записей
+}
+
+// ReadSnapshot чтение текущего состояния файла хранилища без его изменения: записи в порядке
+// первого добавления с примененными обновлениями и окончательными удалениями
+func ReadSnapshot(fileName string) ([]db.Entity, SnapshotStats, error) {
+	var stats SnapshotStats
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer file.Close()
+
+	entities := make([]db.Entity, 0)
+	positions := make(map[string]int) // ID короткого URL -> позиция в entities
+	removed := 0
+	_, stats.Discarded, err = readRecords(file, func(rec record) {
+		stats.Records++
+		pos, ok := positions[rec.ShortID]
+		switch rec.Op {
+		case opAdd, opUpdate:
+			if ok && pos >= 0 {
+				entities[pos] = rec.Entity
+				return
+			}
+			positions[rec.ShortID] = len(entities)
+			entities = append(entities, rec.Entity)
+		case opDelete:
+			if ok && pos >= 0 {
+				positions[rec.ShortID] = -1
+				entities[pos].ShortID = ""
+				removed++
+			}
+		}
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+	if removed == 0 {
+		return entities, stats, nil
+	}
+
+	// окончательно удаленные записи помечены пустым ID
+	snapshot := make([]db.Entity, 0, len(entities)-removed)
+	for _, e := range entities {
+		if e.ShortID != "" {
+			snapshot = append(snapshot, e)
+		}
+	}
+	return snapshot, stats, nil
+}
+
+// WriteEntities запись добавления записей в формате файла хранилища
+func WriteEntities(w io.Writer, entities []db.Entity) error {
+	records := make([]record, len(entities))
+	for i, e := range entities {
+		records[i] = record{Op: opAdd, Entity: e}
+	}
+	return encodeRecords(w, records)
+}
@@ -0,0 +1,117 @@
+package shortid
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// CheckedAlphabet символы ID с контрольным символом: цифры и заглавные буквы без I и L,
+// которые легко спутать с 1, O - с 0 и U - с V
+const CheckedAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// confusables замена похожих символов при поиске
+var confusables = strings.NewReplacer("O", "0", "I", "1", "L", "1", "U", "V")
+
+// Checked случайный ID из length-1 символов CheckedAlphabet и контрольного символа.
+// Контрольный символ (Luhn mod N) обнаруживает любую замену одного символа
+// и большинство перестановок соседних символов
+type Checked struct {
+	length int
+}
+
+func NewChecked(length int) (Checked, error) {
+	if length < 2 {
+		return Checked{}, fmt.Errorf("bad short ID length %d: at least 2 with check character", length)
+	}
+	return Checked{length: length}, nil
+}
+
+// Length длина выдаваемых ID вместе с контрольным символом
+func (g Checked) Length() int {
+	return g.length
+}
+
+func (g Checked) NewID(context.Context) (string, error) {
+	max := big.NewInt(int64(len(CheckedAlphabet)))
+	id := make([]byte, g.length-1, g.length)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		id[i] = CheckedAlphabet[n.Int64()]
+	}
+	return string(append(id, checkChar(id))), nil
+}
+
+// Normalize приведение ID к виду, в котором его выдает Checked: заглавные буквы,
+// 0 вместо O, 1 вместо I и L, V вместо U
+func Normalize(id string) string {
+	return confusables.Replace(strings.ToUpper(id))
+}
+
+// IsChecked ID состоит из символов CheckedAlphabet, то есть мог быть выдан Checked
+func IsChecked(id string) bool {
+	if len(id) < 2 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if strings.IndexByte(CheckedAlphabet, id[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Valid контрольный символ ID совпадает
+func Valid(id string) bool {
+	if !IsChecked(id) {
+		return false
+	}
+	return checkChar([]byte(id[:len(id)-1])) == id[len(id)-1]
+}
+
+// Neighbors ID с правильным контрольным символом, отличающиеся от id заменой одного символа
+// или перестановкой соседних - вероятные ID при опечатке в id
+func Neighbors(id string) []string {
+	if !IsChecked(id) {
+		return nil
+	}
+	var neighbors []string
+	b := []byte(id)
+	for i := range b {
+		original := b[i]
+		for j := 0; j < len(CheckedAlphabet); j++ {
+			if b[i] = CheckedAlphabet[j]; b[i] != original && Valid(string(b)) {
+				neighbors = append(neighbors, string(b))
+			}
+		}
+		b[i] = original
+	}
+	for i := 0; i+1 < len(b); i++ {
+		if b[i] == b[i+1] {
+			continue
+		}
+		b[i], b[i+1] = b[i+1], b[i]
+		if Valid(string(b)) {
+			neighbors = append(neighbors, string(b))
+		}
+		b[i], b[i+1] = b[i+1], b[i]
+	}
+	return neighbors
+}
+
+// checkChar контрольный символ Luhn mod N для payload
+func checkChar(payload []byte) byte {
+	n := len(CheckedAlphabet)
+	factor, sum := 2, 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(CheckedAlphabet, payload[i])
+		factor = 3 - factor
+		sum += addend/n + addend%n
+	}
+	return CheckedAlphabet[(n-sum%n)%n]
+}
@@ -0,0 +1,93 @@
+package shortid
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Sequence источник возрастающих номеров, например последовательность БД
+type Sequence interface {
+	NextSequence(ctx context.Context) (uint64, error)
+}
+
+// Counter последовательность в памяти процесса, начинается со start
+type Counter struct {
+	next uint64
+}
+
+func NewCounter(start uint64) *Counter {
+	return &Counter{next: start}
+}
+
+func (c *Counter) NextSequence(context.Context) (uint64, error) {
+	return atomic.AddUint64(&c.next, 1) - 1, nil
+}
+
+// maxSequenceLength длина ID, при которой 62^n еще помещается в uint64 с запасом
+const maxSequenceLength = 10
+
+// Obfuscated ID из номера последовательности. Номер переставляется взаимно однозначно
+// в пределах ID одной длины, поэтому ID не повторяются, но соседние номера дают непохожие ID.
+// ID имеет длину не меньше minLength и удлиняется, когда номера перестают помещаться
+type Obfuscated struct {
+	seq       Sequence
+	minLength int
+	hash      uint64
+}
+
+// NewObfuscated salt задает перестановку: при другой соли те же номера дают другие ID
+func NewObfuscated(seq Sequence, minLength int, salt string) (*Obfuscated, error) {
+	if minLength <= 0 || minLength > maxSequenceLength {
+		return nil, fmt.Errorf("bad short ID length %d: must be from 1 to %d", minLength, maxSequenceLength)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("shortid:" + salt))
+	return &Obfuscated{seq: seq, minLength: minLength, hash: h.Sum64()}, nil
+}
+
+func (g *Obfuscated) NewID(ctx context.Context) (string, error) {
+	n, err := g.seq.NextSequence(ctx)
+	if err != nil {
+		return "", err
+	}
+	return g.encode(n)
+}
+
+// encode перестановка n среди чисел [0, 62^length) и запись length символами Alphabet
+func (g *Obfuscated) encode(n uint64) (string, error) {
+	length, modulus := g.minLength, pow62(g.minLength)
+	for n >= modulus {
+		if length++; length > maxSequenceLength {
+			return "", fmt.Errorf("sequence %d is out of range", n)
+		}
+		modulus = pow62(length)
+	}
+
+	// n*multiplier+offset по модулю 62^length - перестановка, если multiplier взаимно прост с 62
+	multiplier := g.hash % modulus
+	for multiplier%2 == 0 || multiplier%31 == 0 {
+		multiplier++
+	}
+	offset := bits.RotateLeft64(g.hash, 32) % modulus
+	hi, lo := bits.Mul64(n, multiplier)
+	_, x := bits.Div64(hi, lo, modulus)
+	x = (x + offset) % modulus
+
+	id := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		id[i] = Alphabet[x%62]
+		x /= 62
+	}
+	return string(id), nil
+}
+
+func pow62(n int) uint64 {
+	p := uint64(1)
+	for i := 0; i < n; i++ {
+		p *= 62
+	}
+	return p
+}
@@ -0,0 +1,50 @@
+package shortid
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/google/uuid"
+	"math/big"
+)
+
+// IDGenerator источник коротких ID. Уникальность ID проверяет хранилище: при совпадении
+// с существующим ID запрашивается следующий
+type IDGenerator interface {
+	NewID(ctx context.Context) (string, error)
+}
+
+// Alphabet символы коротких ID
+const Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// UUID ID в виде UUID, как до появления коротких ID
+type UUID struct{}
+
+func (UUID) NewID(context.Context) (string, error) {
+	return uuid.NewString(), nil
+}
+
+// Random случайный ID из length символов Alphabet
+type Random struct {
+	length int
+}
+
+func NewRandom(length int) (Random, error) {
+	if length <= 0 {
+		return Random{}, fmt.Errorf("bad short ID length %d", length)
+	}
+	return Random{length: length}, nil
+}
+
+func (g Random) NewID(context.Context) (string, error) {
+	max := big.NewInt(int64(len(Alphabet)))
+	id := make([]byte, g.length)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		id[i] = Alphabet[n.Int64()]
+	}
+	return string(id), nil
+}
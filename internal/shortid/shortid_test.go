@@ -0,0 +1,123 @@
+package shortid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRandom(t *testing.T) {
+	g, err := NewRandom(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]struct{})
+	for i := 0; i < 1000; i++ {
+		id, err := g.NewID(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 8 || strings.Trim(id, Alphabet) != "" {
+			t.Fatalf("bad ID %q", id)
+		}
+		if _, ok := seen[id]; ok {
+			t.Fatalf("duplicate ID %q", id)
+		}
+		seen[id] = struct{}{}
+	}
+	if _, err = NewRandom(0); err == nil {
+		t.Fatal("expected error for zero length")
+	}
+}
+
+func TestObfuscated(t *testing.T) {
+	ctx := context.Background()
+	g, err := NewObfuscated(NewCounter(0), 2, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// все номера, помещающиеся в 2 символа, и часть трехсимвольных дают разные ID
+	seen := make(map[string]uint64)
+	for n := uint64(0); n < 62*62+1000; n++ {
+		id, err := g.NewID(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 2
+		if n >= 62*62 {
+			expected = 3
+		}
+		if len(id) != expected || strings.Trim(id, Alphabet) != "" {
+			t.Fatalf("sequence %d: bad ID %q", n, id)
+		}
+		if prev, ok := seen[id]; ok {
+			t.Fatalf("sequence %d: ID %q already issued for %d", n, id, prev)
+		}
+		seen[id] = n
+	}
+
+	// другая соль - другие ID
+	other, err := NewObfuscated(NewCounter(0), 2, "pepper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := g.encode(1)
+	b, _ := other.encode(1)
+	if a == b {
+		t.Fatalf("same ID %q for different salts", a)
+	}
+
+	if _, err = g.encode(pow62(maxSequenceLength)); err == nil {
+		t.Fatal("expected out of range error")
+	}
+}
+
+func TestChecked(t *testing.T) {
+	g, err := NewChecked(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		id, err := g.NewID(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 8 || !Valid(id) || Normalize(id) != id {
+			t.Fatalf("bad ID %q", id)
+		}
+
+		// замена любого символа обнаруживается
+		b := []byte(id)
+		for pos := range b {
+			original := b[pos]
+			for j := 0; j < len(CheckedAlphabet); j++ {
+				if b[pos] = CheckedAlphabet[j]; b[pos] != original && Valid(string(b)) {
+					t.Fatalf("ID %q: substitution %q is not detected", id, b)
+				}
+			}
+			b[pos] = original
+		}
+
+		// исходный ID - среди вероятных ID при опечатке
+		typo := []byte(id)
+		typo[3] = CheckedAlphabet[(strings.IndexByte(CheckedAlphabet, typo[3])+1)%len(CheckedAlphabet)]
+		found := false
+		for _, n := range Neighbors(string(typo)) {
+			found = found || n == id
+		}
+		if !found {
+			t.Fatalf("ID %q is not a neighbor of %q", id, typo)
+		}
+	}
+
+	if got := Normalize("abo1-ilu"); got != "AB01-11V" {
+		t.Fatalf("unexpected normalization %q", got)
+	}
+	if IsChecked("AB-1") || IsChecked("A") || !IsChecked("AB1") {
+		t.Fatal("unexpected IsChecked")
+	}
+	if _, err = NewChecked(1); err == nil {
+		t.Fatal("expected error for length 1")
+	}
+}